@@ -2,13 +2,11 @@ package api
 
 import (
 	"context"
-	"log"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
-
-	cfg "github.com/karotte128/karottelib/config"
+	"time"
 
 	"github.com/karotte128/karotteapi"
 	_ "github.com/karotte128/karotteapi/builtin/middleware" // automatically loads all middleware via init()
@@ -20,23 +18,35 @@ import (
 // and mounts each module under its prefix.
 func InitAPI(config karotteapi.Config) {
 	// Load config
-	internal.LoadConfig(config)
+	err := internal.LoadConfig(config)
+	if err != nil {
+		fatal("invalid config", "error", err)
+	}
 
 	// Get server config
 	serverConfig, serverConfigOk := internal.GetServerConfig()
 	if !serverConfigOk {
-		log.Fatal("[SERVER] No server config!")
+		fatal("no server config")
+	}
+
+	// Set up logging
+	internal.ConfigureLogger(serverConfig)
+
+	// Set up tracing
+	err = internal.ConfigureTracing(serverConfig)
+	if err != nil {
+		fatal("invalid tracing config", "error", err)
 	}
 
 	// Get server address
-	addr, addrOk := cfg.GetNestedValue[string](serverConfig, "address")
+	addr, addrOk := internal.GetNestedValue[string](serverConfig, "address")
 	if !addrOk {
-		log.Fatal("[SERVER] No server address config!")
+		fatal("no server address config")
 	}
 
 	// check if address has no value
 	if addr == "" {
-		log.Fatal("[SERVER] address is not configured!")
+		fatal("address is not configured")
 	}
 
 	// A multiplexer to route module-specific handlers.
@@ -45,8 +55,8 @@ func InitAPI(config karotteapi.Config) {
 	// Load all modules of the module registry.
 	internal.LoadRegisteredModules(mux)
 
-	// Apply global middleware to the root mux.
-	handler := internal.ApplyRegisteredMiddleware(mux)
+	// Apply global middleware to the root mux, unmatched routes are answered with problem details.
+	handler := internal.ApplyRegisteredMiddleware(internal.HandleMuxErrors(mux))
 
 	// listen for shutdown notification
 	ctx, stop := signal.NotifyContext(
@@ -58,18 +68,55 @@ func InitAPI(config karotteapi.Config) {
 
 	// start http server
 	go func() {
-		log.Printf("[SERVER] running on %s", addr)
+		internal.Logger().Info("server running", "address", addr)
 		err := http.ListenAndServe(addr, handler)
 
 		if err != nil {
-			log.Fatalf("[SERVER] error: %v", err)
+			fatal("server error", "error", err)
 		}
 	}()
 
-	// shutdown triggered
-	<-ctx.Done()
+	// listen for reload notification
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	defer signal.Stop(reload)
+
+	// reload config until shutdown is triggered
+	for ctx.Err() == nil {
+		select {
+		case <-reload:
+			internal.Logger().Info("reloading config")
+			err := internal.ReloadConfigFromSource()
+			if err != nil {
+				internal.Logger().Error("config reload failed", "error", err)
+			}
 
-	log.Println("[SERVER] shutting down...")
+		case <-ctx.Done():
+		}
+	}
+
+	internal.Logger().Info("shutting down")
 	// shutting down registered modules
 	internal.ShutdownRegisteredModules()
+
+	// export the remaining spans
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	internal.ShutdownTracing(shutdownCtx)
+}
+
+// ExportOpenAPI returns the OpenAPI 3.1 document of all modules enabled in the config,
+// without starting the server. It can be used to write the document in CI and diff it.
+func ExportOpenAPI(config karotteapi.Config) ([]byte, error) {
+	err := internal.LoadConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return internal.OpenAPI()
+}
+
+// fatal logs the error and exits the program.
+func fatal(msg string, args ...any) {
+	internal.Logger().Error(msg, args...)
+	os.Exit(1)
 }
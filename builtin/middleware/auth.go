@@ -0,0 +1,196 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync/atomic"
+
+	"github.com/karotte128/karotteapi"
+	"github.com/karotte128/karotteapi/core"
+)
+
+// The auth middleware authenticates requests with the configured authenticators
+// and stores the principal in the request context (see core.GetPrincipal).
+//
+// [middleware.auth]
+// enable = true
+// authenticators = ["apikey", "basic"]  # tried in this order
+// required = false                       # reject unauthenticated requests
+// realm = "KarotteAPI"
+//
+// [middleware.auth.apikey]               # config of the apikey authenticator
+// ...
+//
+// [middleware.auth.modules.example]      # per-module override
+// required = true
+
+var authMiddleware = karotteapi.Middleware{
+	Name:           "auth",
+	Handler:        authHandler,
+	Priority:       5,
+	ForceEnable:    false,
+	OnConfigChange: authConfigChange,
+}
+
+// namedAuthenticator is an authenticator with the name it is configured with.
+type namedAuthenticator struct {
+	name          string
+	authenticator karotteapi.Authenticator
+}
+
+// authState is the config of the auth middleware.
+type authState struct {
+	authenticators []namedAuthenticator
+	required       bool
+	modules        map[string]bool
+	challenge      string
+
+	// err is set if the config is invalid. All requests are rejected in this case.
+	err error
+}
+
+// authCurrent is the current config of the auth middleware. It is replaced on config change.
+var authCurrent atomic.Pointer[authState]
+
+// newAuthState creates the auth config from the middleware config block.
+func newAuthState(conf karotteapi.Config) (*authState, error) {
+	state := &authState{
+		required: core.GetNestedValueOrDefault(conf, false, "required"),
+		modules:  map[string]bool{},
+	}
+
+	realm := core.GetNestedValueOrDefault(conf, "KarotteAPI", "realm")
+
+	names, _ := core.GetNestedValue[[]string](conf, "authenticators")
+	for _, name := range names {
+		factory, ok := core.GetAuthenticatorFactory(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown authenticator %q", name)
+		}
+
+		authConf, _ := core.GetNestedValue[map[string]any](conf, name)
+		authenticator, err := factory(authConf)
+		if err != nil {
+			return nil, fmt.Errorf("authenticator %s: %w", name, err)
+		}
+
+		state.authenticators = append(state.authenticators, namedAuthenticator{name: name, authenticator: authenticator})
+	}
+
+	// the WWW-Authenticate challenge tells clients how to authenticate
+	if slices.Contains(names, "basic") {
+		state.challenge = fmt.Sprintf("Basic realm=%q", realm)
+	} else {
+		state.challenge = fmt.Sprintf("Bearer realm=%q", realm)
+	}
+
+	modules, _ := core.GetNestedValue[map[string]any](conf, "modules")
+	for module := range modules {
+		required, ok := core.GetNestedValue[bool](modules, module, "required")
+		if ok {
+			state.modules[module] = required
+		}
+	}
+
+	return state, nil
+}
+
+// isRequired reports whether the request must be authenticated.
+func (state *authState) isRequired(r *http.Request) bool {
+	module, ok := core.GetModuleForRequest(r)
+	if ok {
+		required, ok := state.modules[module]
+		if ok {
+			return required
+		}
+	}
+	return state.required
+}
+
+// authenticate tries all authenticators in order.
+// It returns nil without error if the request contains no credentials.
+func (state *authState) authenticate(r *http.Request) (*karotteapi.Principal, error) {
+	for _, auth := range state.authenticators {
+		principal, err := auth.authenticator.Authenticate(r)
+		if errors.Is(err, karotteapi.ErrNoCredentials) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if principal == nil {
+			continue
+		}
+
+		if principal.Method == "" {
+			principal.Method = auth.name
+		}
+		return principal, nil
+	}
+
+	return nil, nil
+}
+
+func authHandler(next http.Handler) http.Handler {
+	conf, _ := core.GetMiddlewareConfig("auth")
+	state, err := newAuthState(conf)
+	if err != nil {
+		core.MiddlewareLogger("auth").Error("invalid config, rejecting all requests", "error", err)
+		state = &authState{err: err}
+	}
+	authCurrent.Store(state)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		state := authCurrent.Load()
+
+		if state.err != nil {
+			rejectRequest(w, r, core.NewHTTPError(http.StatusInternalServerError, "authentication_unavailable", "authentication is not available"))
+			return
+		}
+
+		// the request was already authenticated by another middleware (e.g. jwt)
+		if _, ok := core.GetPrincipal(r.Context()); ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		principal, err := state.authenticate(r)
+		if err != nil {
+			core.MiddlewareLogger("auth").InfoContext(r.Context(), "request rejected", "method", r.Method, "path", r.URL.Path, "error", err)
+			w.Header().Set("WWW-Authenticate", state.challenge)
+			rejectRequest(w, r, core.NewHTTPError(http.StatusUnauthorized, "invalid_credentials", "invalid credentials"))
+			return
+		}
+
+		if principal == nil {
+			if state.isRequired(r) {
+				w.Header().Set("WWW-Authenticate", state.challenge)
+				rejectRequest(w, r, core.NewHTTPError(http.StatusUnauthorized, "unauthenticated", "authentication required"))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(core.SetPrincipal(r.Context(), principal)))
+	})
+}
+
+// authConfigChange rebuilds the authenticators when the config changed.
+// If the new config is invalid, the old config is kept.
+func authConfigChange(oldConfig, newConfig karotteapi.Config) error {
+	state, err := newAuthState(newConfig)
+	if err != nil {
+		return err
+	}
+
+	authCurrent.Store(state)
+	return nil
+}
+
+func init() {
+	core.RegisterMiddleware(authMiddleware)
+}
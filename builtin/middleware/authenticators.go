@@ -0,0 +1,363 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/karotte128/karotteapi"
+	"github.com/karotte128/karotteapi/core"
+)
+
+// This file contains the builtin authenticators of the auth middleware.
+// Credentials are configured in tables. The value of an entry is either a
+// string or a table with additional "id" and "roles" values:
+//
+// [middleware.auth.apikey.keys]
+// "key-1" = "service-a"
+// "key-2" = { id = "service-b", roles = ["admin"] }
+
+// readEntry reads a credential entry. If the entry is a table, the value is read from valueField.
+func readEntry(entry any, valueField string) (value string, id string, roles []string) {
+	switch e := entry.(type) {
+	case string:
+		return e, "", nil
+
+	case map[string]any:
+		value, _ = core.GetNestedValue[string](e, valueField)
+		id, _ = core.GetNestedValue[string](e, "id")
+		roles, _ = core.GetNestedValue[[]string](e, "roles")
+		return value, id, roles
+	}
+
+	return "", "", nil
+}
+
+// tokenEntry is a static token (API key or bearer token) and its principal.
+type tokenEntry struct {
+	hash      [32]byte
+	principal karotteapi.Principal
+}
+
+// readTokens reads a table of static tokens.
+func readTokens(conf karotteapi.Config, table string) ([]tokenEntry, error) {
+	tokens, _ := core.GetNestedValue[map[string]any](conf, table)
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("no %s configured", table)
+	}
+
+	var entries []tokenEntry
+	for token, entry := range tokens {
+		id, _, roles := readEntry(entry, "id")
+		if id == "" {
+			return nil, fmt.Errorf("%s entry has no id", table)
+		}
+
+		entries = append(entries, tokenEntry{
+			hash:      sha256.Sum256([]byte(token)),
+			principal: karotteapi.Principal{ID: id, Roles: roles},
+		})
+	}
+	return entries, nil
+}
+
+// findToken looks up the token in constant time.
+func findToken(entries []tokenEntry, token string) (*karotteapi.Principal, bool) {
+	hash := sha256.Sum256([]byte(token))
+
+	var found *karotteapi.Principal
+	for _, entry := range entries {
+		if subtle.ConstantTimeCompare(hash[:], entry.hash[:]) == 1 {
+			principal := entry.principal
+			found = &principal
+		}
+	}
+	return found, found != nil
+}
+
+// apiKeyAuthenticator authenticates requests with a static API key in a header.
+//
+// [middleware.auth.apikey]
+// header = "X-API-Key"
+// [middleware.auth.apikey.keys]
+// "<key>" = "<principal id>"
+type apiKeyAuthenticator struct {
+	header string
+	keys   []tokenEntry
+}
+
+func newAPIKeyAuthenticator(conf karotteapi.Config) (karotteapi.Authenticator, error) {
+	keys, err := readTokens(conf, "keys")
+	if err != nil {
+		return nil, err
+	}
+
+	return &apiKeyAuthenticator{
+		header: core.GetNestedValueOrDefault(conf, "X-API-Key", "header"),
+		keys:   keys,
+	}, nil
+}
+
+func (a *apiKeyAuthenticator) Authenticate(r *http.Request) (*karotteapi.Principal, error) {
+	key := r.Header.Get(a.header)
+	if key == "" {
+		return nil, karotteapi.ErrNoCredentials
+	}
+
+	principal, ok := findToken(a.keys, key)
+	if !ok {
+		return nil, errors.New("unknown api key")
+	}
+	return principal, nil
+}
+
+// bearerAuthenticator authenticates requests with a static bearer token.
+//
+// [middleware.auth.bearer.tokens]
+// "<token>" = "<principal id>"
+type bearerAuthenticator struct {
+	tokens []tokenEntry
+}
+
+func newBearerAuthenticator(conf karotteapi.Config) (karotteapi.Authenticator, error) {
+	tokens, err := readTokens(conf, "tokens")
+	if err != nil {
+		return nil, err
+	}
+
+	return &bearerAuthenticator{tokens: tokens}, nil
+}
+
+func (a *bearerAuthenticator) Authenticate(r *http.Request) (*karotteapi.Principal, error) {
+	token, ok := bearerToken(r)
+	if !ok {
+		return nil, karotteapi.ErrNoCredentials
+	}
+
+	principal, ok := findToken(a.tokens, token)
+	if !ok {
+		return nil, errors.New("unknown bearer token")
+	}
+	return principal, nil
+}
+
+// bearerToken returns the token of the "Authorization: Bearer <token>" header.
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
+
+// basicAuthenticator authenticates requests with HTTP Basic auth.
+// The passwords are stored as bcrypt hashes.
+//
+// [middleware.auth.basic.users]
+// alice = "$2a$10$..."
+type basicAuthenticator struct {
+	users map[string]basicUser
+
+	// dummyHash is compared for unknown users, so they take as long as known users
+	// and the response time does not reveal which usernames exist.
+	dummyHash []byte
+}
+
+// basicUser is a user of the basicAuthenticator.
+type basicUser struct {
+	hash      []byte
+	principal karotteapi.Principal
+}
+
+func newBasicAuthenticator(conf karotteapi.Config) (karotteapi.Authenticator, error) {
+	users, _ := core.GetNestedValue[map[string]any](conf, "users")
+	if len(users) == 0 {
+		return nil, errors.New("no users configured")
+	}
+
+	auth := &basicAuthenticator{users: map[string]basicUser{}}
+	maxCost := bcrypt.MinCost
+	for name, entry := range users {
+		hash, id, roles := readEntry(entry, "hash")
+		cost, err := bcrypt.Cost([]byte(hash))
+		if err != nil {
+			return nil, fmt.Errorf("user %s has no valid bcrypt hash: %w", name, err)
+		}
+		maxCost = max(maxCost, cost)
+		if id == "" {
+			id = name
+		}
+
+		auth.users[name] = basicUser{
+			hash:      []byte(hash),
+			principal: karotteapi.Principal{ID: id, Roles: roles},
+		}
+	}
+
+	// the dummy hash uses the highest cost of the users, so it is never faster than a real one
+	dummyHash, err := bcrypt.GenerateFromPassword([]byte("dummy password"), maxCost)
+	if err != nil {
+		return nil, err
+	}
+	auth.dummyHash = dummyHash
+
+	return auth, nil
+}
+
+func (a *basicAuthenticator) Authenticate(r *http.Request) (*karotteapi.Principal, error) {
+	name, password, ok := r.BasicAuth()
+	if !ok {
+		return nil, karotteapi.ErrNoCredentials
+	}
+
+	user, ok := a.users[name]
+	if !ok {
+		bcrypt.CompareHashAndPassword(a.dummyHash, []byte(password))
+		return nil, errors.New("unknown user")
+	}
+
+	err := bcrypt.CompareHashAndPassword(user.hash, []byte(password))
+	if err != nil {
+		return nil, errors.New("wrong password")
+	}
+
+	principal := user.principal
+	return &principal, nil
+}
+
+// hmacAuthenticator authenticates requests signed with a shared secret.
+//
+// The request contains the headers:
+//
+//	Authorization: HMAC <key id>:<base64 signature>
+//	X-Timestamp: <unix seconds>
+//
+// The signature is the HMAC-SHA256 of
+//
+//	<method>\n<path with query>\n<timestamp>\n<hex sha256 of the body>
+//
+// [middleware.auth.hmac]
+// max_skew = 300          # seconds the timestamp may differ from the server time
+// max_body = 10485760     # bytes of the body that are read to verify the signature
+// [middleware.auth.hmac.secrets]
+// "<key id>" = "<secret>"
+type hmacAuthenticator struct {
+	secrets map[string]hmacSecret
+	maxSkew time.Duration
+	maxBody int64
+}
+
+// hmacSecret is a shared secret of the hmacAuthenticator.
+type hmacSecret struct {
+	secret    []byte
+	principal karotteapi.Principal
+}
+
+func newHMACAuthenticator(conf karotteapi.Config) (karotteapi.Authenticator, error) {
+	secrets, _ := core.GetNestedValue[map[string]any](conf, "secrets")
+	if len(secrets) == 0 {
+		return nil, errors.New("no secrets configured")
+	}
+
+	auth := &hmacAuthenticator{
+		secrets: map[string]hmacSecret{},
+		maxSkew: configSeconds(conf, 5*time.Minute, "max_skew"),
+		maxBody: configInt(conf, 10<<20, "max_body"),
+	}
+
+	for keyID, entry := range secrets {
+		secret, id, roles := readEntry(entry, "secret")
+		if secret == "" {
+			return nil, fmt.Errorf("key %s has no secret", keyID)
+		}
+		if id == "" {
+			id = keyID
+		}
+
+		auth.secrets[keyID] = hmacSecret{
+			secret:    []byte(secret),
+			principal: karotteapi.Principal{ID: id, Roles: roles},
+		}
+	}
+
+	return auth, nil
+}
+
+func (a *hmacAuthenticator) Authenticate(r *http.Request) (*karotteapi.Principal, error) {
+	scheme, credentials, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "HMAC") {
+		return nil, karotteapi.ErrNoCredentials
+	}
+
+	keyID, encoded, ok := strings.Cut(credentials, ":")
+	if !ok {
+		return nil, errors.New("malformed hmac credentials")
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errors.New("malformed hmac signature")
+	}
+
+	secret, ok := a.secrets[keyID]
+	if !ok {
+		return nil, errors.New("unknown hmac key")
+	}
+
+	timestamp := r.Header.Get("X-Timestamp")
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return nil, errors.New("missing or malformed timestamp")
+	}
+
+	skew := time.Since(time.Unix(seconds, 0))
+	if skew > a.maxSkew || skew < -a.maxSkew {
+		return nil, errors.New("timestamp out of range")
+	}
+
+	// read the body to hash it and restore it for the next handler
+	var body []byte
+	if r.Body != nil {
+		body, err = io.ReadAll(io.LimitReader(r.Body, a.maxBody+1))
+		r.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed reading body: %w", err)
+		}
+		if int64(len(body)) > a.maxBody {
+			return nil, errors.New("body too large to verify")
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	bodyHash := sha256.Sum256(body)
+	message := r.Method + "\n" + r.URL.RequestURI() + "\n" + timestamp + "\n" + hex.EncodeToString(bodyHash[:])
+
+	mac := hmac.New(sha256.New, secret.secret)
+	mac.Write([]byte(message))
+
+	if !hmac.Equal(mac.Sum(nil), signature) {
+		return nil, errors.New("invalid hmac signature")
+	}
+
+	principal := secret.principal
+	return &principal, nil
+}
+
+func init() {
+	core.RegisterAuthenticator("apikey", newAPIKeyAuthenticator)
+	core.RegisterAuthenticator("bearer", newBearerAuthenticator)
+	core.RegisterAuthenticator("basic", newBasicAuthenticator)
+	core.RegisterAuthenticator("hmac", newHMACAuthenticator)
+}
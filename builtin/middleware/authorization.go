@@ -0,0 +1,157 @@
+package middleware
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"github.com/karotte128/karotteapi"
+	"github.com/karotte128/karotteapi/core"
+	"github.com/karotte128/karotteapi/internal"
+)
+
+// The authorization middleware checks the permissions required by a module
+// for the principal set by the auth or jwt middleware.
+// If roles are configured, it sets a role based permission provider (see core.SetPermissionProvider).
+// Without roles, a provider set by the application is kept.
+// Per-route permissions are declared in the module with core.Require.
+//
+// [middleware.authorization]
+// enable = true
+// roles_file = "roles.toml"                # roles from a file, optional
+//
+// [middleware.authorization.roles]         # roles from the config, optional
+// admin = ["*"]
+// reader = ["orders:read"]
+//
+// [middleware.authorization.modules.orders]
+// permissions = ["orders:read"]            # required for every request to the module
+
+var authorizationMiddleware = karotteapi.Middleware{
+	Name:           "authorization",
+	Handler:        authorizationHandler,
+	Priority:       4,
+	ForceEnable:    false,
+	OnConfigChange: authorizationConfigChange,
+}
+
+// authorizationState is the config of the authorization middleware.
+type authorizationState struct {
+	// modules holds the required permissions of each module.
+	modules map[string][]string
+
+	// provider is the permission provider created from the configured roles, if any.
+	provider karotteapi.PermissionProvider
+
+	// err is set if the config is invalid. All requests are rejected in this case.
+	err error
+}
+
+// authorizationCurrent is the current config of the authorization middleware. It is replaced on config change.
+var authorizationCurrent atomic.Pointer[authorizationState]
+
+// installedProvider is the permission provider the middleware set last, nil if it set none.
+// It is used to tell it apart from a provider set by the application.
+var installedProvider karotteapi.PermissionProvider
+
+// installedLock guards installedProvider.
+var installedLock sync.Mutex
+
+// newAuthorizationState creates the authorization config from the middleware config block.
+func newAuthorizationState(conf karotteapi.Config) (*authorizationState, error) {
+	state := &authorizationState{modules: map[string][]string{}}
+
+	roles, err := core.ReadRoles(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	if path, ok := core.GetNestedValue[string](conf, "roles_file"); ok {
+		state.provider, err = core.NewRoleFilePermissionProvider(path)
+		if err != nil {
+			return nil, err
+		}
+	} else if len(roles) > 0 {
+		state.provider = core.NewRolePermissionProvider(roles)
+	}
+
+	modules, _ := core.GetNestedValue[map[string]any](conf, "modules")
+	for module := range modules {
+		permissions, ok := core.GetNestedValue[[]string](modules, module, "permissions")
+		if ok {
+			state.modules[module] = permissions
+		}
+	}
+
+	return state, nil
+}
+
+// apply makes the state the current config and sets the permission provider of the roles.
+// Without roles, the provider is only removed if the middleware installed it, so roles removed
+// from the config do not stay active after a reload, but a provider of the application is kept.
+func (state *authorizationState) apply() {
+	installedLock.Lock()
+	defer installedLock.Unlock()
+
+	if state.provider != nil {
+		core.SetPermissionProvider(state.provider)
+		installedProvider = state.provider
+	} else if installedProvider != nil {
+		// the application may have replaced the provider in the meantime
+		internal.ReplacePermissionProvider(installedProvider, nil)
+		installedProvider = nil
+	}
+
+	authorizationCurrent.Store(state)
+}
+
+func authorizationHandler(next http.Handler) http.Handler {
+	conf, _ := core.GetMiddlewareConfig("authorization")
+	state, err := newAuthorizationState(conf)
+	if err != nil {
+		core.MiddlewareLogger("authorization").Error("invalid config, rejecting all requests", "error", err)
+		state = &authorizationState{err: err}
+	}
+	state.apply()
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		state := authorizationCurrent.Load()
+
+		if state.err != nil {
+			rejectRequest(w, r, core.NewHTTPError(http.StatusInternalServerError, "authorization_unavailable", "authorization is not available"))
+			return
+		}
+
+		module, ok := core.GetModuleForRequest(r)
+		if ok {
+			permissions := state.modules[module]
+			if len(permissions) > 0 {
+				allowed := true
+				logAnswered(w, r, func(w http.ResponseWriter) {
+					allowed = core.Authorize(w, r, permissions...)
+				})
+				if !allowed {
+					return
+				}
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authorizationConfigChange applies the new config.
+// If the new config is invalid, the old config is kept.
+func authorizationConfigChange(oldConfig, newConfig karotteapi.Config) error {
+	state, err := newAuthorizationState(newConfig)
+	if err != nil {
+		return err
+	}
+
+	state.apply()
+	return nil
+}
+
+func init() {
+	core.RegisterMiddleware(authorizationMiddleware)
+}
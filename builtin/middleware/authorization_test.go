@@ -0,0 +1,83 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/karotte128/karotteapi"
+	"github.com/karotte128/karotteapi/core"
+)
+
+// customPermissionProvider grants only the permission "orders:read", like a provider of the application.
+type customPermissionProvider struct{}
+
+func (customPermissionProvider) HasPermission(principal *karotteapi.Principal, permission string) (bool, error) {
+	return permission == "orders:read", nil
+}
+
+// requirePermission sends a request of alice to a route that requires the permission and returns the status.
+func requirePermission(permission string) int {
+	route := core.Require(permission, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	r := httptest.NewRequest(http.MethodGet, "/orders/1", nil)
+	r = r.WithContext(core.SetPrincipal(r.Context(), &karotteapi.Principal{ID: "alice", Roles: []string{"reader"}}))
+	w := httptest.NewRecorder()
+	route.ServeHTTP(w, r)
+	return w.Code
+}
+
+func TestAuthorizationKeepsCustomProvider(t *testing.T) {
+	t.Cleanup(func() { core.SetPermissionProvider(nil) })
+	core.SetPermissionProvider(customPermissionProvider{})
+
+	// creating the handler applies the config on startup
+	authorizationHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	if status := requirePermission("orders:read"); status != http.StatusOK {
+		t.Errorf("after startup: status %d", status)
+	}
+
+	// a reload without roles keeps the provider
+	err := authorizationConfigChange(nil, karotteapi.Config{"modules": map[string]any{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := requirePermission("orders:read"); status != http.StatusOK {
+		t.Errorf("after reload: status %d", status)
+	}
+	if status := requirePermission("orders:write"); status != http.StatusForbidden {
+		t.Errorf("denied permission after reload: status %d", status)
+	}
+}
+
+func TestAuthorizationRemovesItsOwnProvider(t *testing.T) {
+	t.Cleanup(func() { core.SetPermissionProvider(nil) })
+
+	err := authorizationConfigChange(nil, karotteapi.Config{"roles": map[string]any{"reader": []any{"orders:write"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := requirePermission("orders:write"); status != http.StatusOK {
+		t.Errorf("with roles: status %d", status)
+	}
+
+	// removed roles do not stay active
+	err = authorizationConfigChange(nil, karotteapi.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := requirePermission("orders:write"); status == http.StatusOK {
+		t.Error("removed role still grants the permission")
+	}
+
+	// a provider the application set later is not removed
+	core.SetPermissionProvider(customPermissionProvider{})
+	err = authorizationConfigChange(nil, karotteapi.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := requirePermission("orders:read"); status != http.StatusOK {
+		t.Errorf("custom provider after reload: status %d", status)
+	}
+}
@@ -0,0 +1,98 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/karotte128/karotteapi"
+	"github.com/karotte128/karotteapi/core"
+)
+
+// trustedProxies are the networks of the proxies whose X-Forwarded-For header is trusted.
+type trustedProxies []netip.Prefix
+
+// readTrustedProxies reads the "trusted_proxies" list of CIDRs (or single addresses) from the config.
+func readTrustedProxies(conf karotteapi.Config) (trustedProxies, error) {
+	if _, ok := core.GetNestedValue[bool](conf, "trust_proxy"); ok {
+		return nil, errors.New("trust_proxy is replaced by trusted_proxies, list the addresses of your proxies")
+	}
+
+	var proxies trustedProxies
+	for _, cidr := range core.GetNestedValueOrDefault(conf, []string{}, "trusted_proxies") {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(cidr)
+			if addrErr != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q: %w", cidr, err)
+			}
+			prefix = netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen())
+		}
+		proxies = append(proxies, prefix.Masked())
+	}
+	return proxies, nil
+}
+
+// contains reports whether addr is a trusted proxy.
+func (proxies trustedProxies) contains(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range proxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the IP address of the client.
+// If the direct peer is a trusted proxy, the X-Forwarded-For header is read from the right
+// and the first address that is not a trusted proxy is the client. The addresses left of it
+// are set by the client and can be forged, so they are never used.
+func clientIP(r *http.Request, proxies trustedProxies) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	peer, err := netip.ParseAddr(host)
+	if err != nil || !proxies.contains(peer) {
+		return host
+	}
+
+	client := peer
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, ok := parseForwardedAddr(hops[i])
+		if !ok {
+			// the hop can not be trusted, the last trusted hop got the request from it
+			break
+		}
+		client = addr
+		if !proxies.contains(addr) {
+			break
+		}
+	}
+	return client.Unmap().String()
+}
+
+// parseForwardedAddr parses an X-Forwarded-For entry, with or without port.
+func parseForwardedAddr(hop string) (netip.Addr, bool) {
+	hop = strings.TrimSpace(hop)
+
+	addr, err := netip.ParseAddr(hop)
+	if err == nil {
+		return addr, true
+	}
+
+	addrPort, err := netip.ParseAddrPort(hop)
+	if err == nil {
+		return addrPort.Addr(), true
+	}
+	return netip.Addr{}, false
+}
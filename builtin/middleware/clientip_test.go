@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/karotte128/karotteapi"
+)
+
+func TestClientIP(t *testing.T) {
+	proxies, err := readTrustedProxies(karotteapi.Config{"trusted_proxies": []any{"10.0.0.0/8", "2001:db8::1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		want       string
+	}{
+		{"no proxy", "192.0.2.1:1234", nil, "192.0.2.1"},
+		{"untrusted peer", "192.0.2.1:1234", []string{"203.0.113.9"}, "192.0.2.1"},
+		{"trusted peer", "10.0.0.1:1234", []string{"203.0.113.9"}, "203.0.113.9"},
+		{"forged entry", "10.0.0.1:1234", []string{"1.2.3.4, 203.0.113.9"}, "203.0.113.9"},
+		{"proxy chain", "10.0.0.1:1234", []string{"1.2.3.4, 203.0.113.9, 10.1.1.1", "10.2.2.2"}, "203.0.113.9"},
+		{"only proxies", "10.0.0.1:1234", []string{"10.1.1.1"}, "10.1.1.1"},
+		{"no header", "10.0.0.1:1234", nil, "10.0.0.1"},
+		{"invalid entry", "10.0.0.1:1234", []string{"203.0.113.9, garbage, 10.1.1.1"}, "10.1.1.1"},
+		{"entry with port", "10.0.0.1:1234", []string{"203.0.113.9:5555"}, "203.0.113.9"},
+		{"ipv6 proxy", "[2001:db8::1]:1234", []string{"2001:db8::2"}, "2001:db8::2"},
+		{"ipv4 mapped", "[::ffff:10.0.0.1]:1234", []string{"203.0.113.9"}, "203.0.113.9"},
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = test.remoteAddr
+		for _, value := range test.forwarded {
+			r.Header.Add("X-Forwarded-For", value)
+		}
+
+		if got := clientIP(r, proxies); got != test.want {
+			t.Errorf("%s: clientIP = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestReadTrustedProxiesErrors(t *testing.T) {
+	for _, conf := range []karotteapi.Config{
+		{"trusted_proxies": []any{"not an address"}},
+		{"trust_proxy": true},
+	} {
+		if _, err := readTrustedProxies(conf); err == nil {
+			t.Errorf("%v: no error", conf)
+		}
+	}
+}
@@ -0,0 +1,496 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zlib"
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/karotte128/karotteapi"
+	"github.com/karotte128/karotteapi/core"
+)
+
+// The compression middleware compresses responses with the best encoding the client accepts
+// (Accept-Encoding) and decompresses request bodies sent with a Content-Encoding.
+// Responses are only compressed if they have an allowed Content-Type and at least min_size bytes.
+// Flushed responses (e.g. server-sent events) are compressed regardless of their size.
+// It wraps the contentType middleware, so the Content-Type is known when the response is compressed.
+//
+// [middleware.compression]
+// enable = true
+// encodings = ["zstd", "br", "gzip", "deflate"]   # in the order of preference
+// level = "default"                                # fastest, default or best
+// min_size = 1024                                  # bytes
+// content_types = ["text/*", "application/json", "application/*+json", "application/xml", "application/*+xml", "application/javascript", "image/svg+xml"]
+// decompress_requests = true
+// max_decompressed_size = 10485760                 # bytes, larger request bodies are rejected with 413
+
+var compressionMiddleware = karotteapi.Middleware{
+	Name:           "compression",
+	Handler:        compressionHandler,
+	Priority:       25,
+	ForceEnable:    false,
+	OnConfigChange: compressionConfigChange,
+}
+
+// compressor is a writer that compresses the data written to it.
+type compressor interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// compressionLevels are the supported levels.
+var compressionLevels = []string{"fastest", "default", "best"}
+
+// compressionEncoding creates the compressors and decompressors of a content coding.
+type compressionEncoding struct {
+	newCompressor   func(level string) compressor
+	newDecompressor func(r io.Reader) (io.ReadCloser, error)
+}
+
+// compressionEncodings are the supported content codings by their name in Accept-Encoding.
+var compressionEncodings = map[string]compressionEncoding{
+	"gzip": {
+		newCompressor: func(level string) compressor {
+			w, _ := gzip.NewWriterLevel(nil, flateLevel(level))
+			return w
+		},
+		newDecompressor: func(r io.Reader) (io.ReadCloser, error) {
+			return gzip.NewReader(r)
+		},
+	},
+	// deflate in HTTP is the zlib format, not raw deflate
+	"deflate": {
+		newCompressor: func(level string) compressor {
+			w, _ := zlib.NewWriterLevel(nil, flateLevel(level))
+			return w
+		},
+		newDecompressor: zlib.NewReader,
+	},
+	"br": {
+		newCompressor: func(level string) compressor {
+			quality := brotli.DefaultCompression
+			switch level {
+			case "fastest":
+				quality = brotli.BestSpeed
+			case "best":
+				quality = brotli.BestCompression
+			}
+			return brotli.NewWriterLevel(nil, quality)
+		},
+		newDecompressor: func(r io.Reader) (io.ReadCloser, error) {
+			return io.NopCloser(brotli.NewReader(r)), nil
+		},
+	},
+	"zstd": {
+		newCompressor: func(level string) compressor {
+			speed := zstd.SpeedDefault
+			switch level {
+			case "fastest":
+				speed = zstd.SpeedFastest
+			case "best":
+				speed = zstd.SpeedBestCompression
+			}
+			// browsers only accept windows up to 8 MiB
+			w, _ := zstd.NewWriter(nil, zstd.WithEncoderLevel(speed), zstd.WithEncoderConcurrency(1), zstd.WithWindowSize(8<<20))
+			return w
+		},
+		newDecompressor: func(r io.Reader) (io.ReadCloser, error) {
+			decoder, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+			if err != nil {
+				return nil, err
+			}
+			return decoder.IOReadCloser(), nil
+		},
+	},
+}
+
+// flateLevel returns the gzip and zlib level of a compression level.
+func flateLevel(level string) int {
+	switch level {
+	case "fastest":
+		return gzip.BestSpeed
+	case "best":
+		return gzip.BestCompression
+	}
+	return gzip.DefaultCompression
+}
+
+// defaultCompressionTypes are the content types that are compressed if none are configured.
+var defaultCompressionTypes = []string{
+	"text/*",
+	"application/json",
+	"application/*+json",
+	"application/xml",
+	"application/*+xml",
+	"application/javascript",
+	"image/svg+xml",
+}
+
+// compressionState is the config of the compression middleware.
+type compressionState struct {
+	encodings    []string
+	minSize      int
+	contentTypes []string
+
+	decompressRequests  bool
+	maxDecompressedSize int64
+
+	// pools holds the compressors of each encoding for reuse.
+	pools map[string]*sync.Pool
+}
+
+// compressionCurrent is the current config of the compression middleware. It is replaced on config change.
+var compressionCurrent atomic.Pointer[compressionState]
+
+// newCompressionState creates the compression config from the middleware config block.
+func newCompressionState(conf karotteapi.Config) (*compressionState, error) {
+	state := &compressionState{
+		encodings:           core.GetNestedValueOrDefault(conf, []string{"zstd", "br", "gzip", "deflate"}, "encodings"),
+		minSize:             int(configInt(conf, 1024, "min_size")),
+		contentTypes:        slices.Clone(core.GetNestedValueOrDefault(conf, defaultCompressionTypes, "content_types")),
+		decompressRequests:  core.GetNestedValueOrDefault(conf, true, "decompress_requests"),
+		maxDecompressedSize: configInt(conf, 10<<20, "max_decompressed_size"),
+		pools:               map[string]*sync.Pool{},
+	}
+
+	level := core.GetNestedValueOrDefault(conf, "default", "level")
+	if !slices.Contains(compressionLevels, level) {
+		return nil, fmt.Errorf("unknown level %q", level)
+	}
+
+	for _, name := range state.encodings {
+		encoding, ok := compressionEncodings[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown encoding %q", name)
+		}
+		state.pools[name] = &sync.Pool{New: func() any { return encoding.newCompressor(level) }}
+	}
+
+	for i, contentType := range state.contentTypes {
+		state.contentTypes[i] = strings.ToLower(contentType)
+	}
+	return state, nil
+}
+
+// negotiate returns the configured encoding with the highest quality in the Accept-Encoding header,
+// or "" if the response is not compressed. Encodings with the same quality are chosen in the configured order.
+func (state *compressionState) negotiate(acceptEncoding string) string {
+	qualities := map[string]float64{}
+	for part := range strings.SplitSeq(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		quality := 1.0
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			quality, err = strconv.ParseFloat(q, 64)
+			if err != nil {
+				continue
+			}
+		}
+		qualities[name] = quality
+	}
+
+	best, bestQuality := "", 0.0
+	for _, name := range state.encodings {
+		quality, ok := qualities[name]
+		if !ok {
+			quality, ok = qualities["*"]
+		}
+		if ok && quality > bestQuality {
+			best, bestQuality = name, quality
+		}
+	}
+	return best
+}
+
+// compressible reports whether responses of the content type are compressed.
+func (state *compressionState) compressible(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	mainType, subType, _ := strings.Cut(mediaType, "/")
+
+	for _, allowed := range state.contentTypes {
+		allowedMain, allowedSub, _ := strings.Cut(allowed, "/")
+		if allowedMain != mainType {
+			continue
+		}
+		if allowedSub == subType || allowedSub == "*" {
+			return true
+		}
+		// application/*+json matches all types with the +json suffix
+		if suffix, ok := strings.CutPrefix(allowedSub, "*"); ok && strings.HasSuffix(subType, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// compressionResponseWriter buffers the start of the response until it knows
+// whether the response is compressed.
+type compressionResponseWriter struct {
+	*core.ResponseWriter
+	state    *compressionState
+	encoding string
+
+	status  int
+	buffer  []byte
+	decided bool
+
+	// compressor is nil if the response is not compressed.
+	compressor compressor
+}
+
+func (cw *compressionResponseWriter) WriteHeader(status int) {
+	if cw.decided || cw.status != 0 {
+		return
+	}
+	// informational responses are sent right away and do not end the header
+	if status < 200 {
+		cw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	cw.status = status
+}
+
+func (cw *compressionResponseWriter) Write(b []byte) (int, error) {
+	if !cw.decided {
+		cw.buffer = append(cw.buffer, b...)
+		if len(cw.buffer) < cw.state.minSize {
+			return len(b), nil
+		}
+
+		err := cw.decide(false)
+		if err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+
+	if cw.compressor != nil {
+		return cw.compressor.Write(b)
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+// ReadFrom passes uncompressed responses to the wrapped writer, everything else goes through Write.
+func (cw *compressionResponseWriter) ReadFrom(src io.Reader) (int64, error) {
+	if cw.decided && cw.compressor == nil {
+		return cw.ResponseWriter.ReadFrom(src)
+	}
+	return io.Copy(struct{ io.Writer }{cw}, src)
+}
+
+// decide starts the response, compressed if possible, and writes the buffered data.
+// Flushed responses are compressed regardless of their size.
+func (cw *compressionResponseWriter) decide(flushed bool) error {
+	cw.decided = true
+	if cw.shouldCompress(flushed) {
+		header := cw.Header()
+		header.Set("Content-Encoding", cw.encoding)
+		header.Del("Content-Length")
+		header.Del("Accept-Ranges")
+		// the compressed representation is not byte-identical anymore
+		if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			header.Set("ETag", "W/"+etag)
+		}
+
+		cw.compressor = cw.state.pools[cw.encoding].Get().(compressor)
+		cw.compressor.Reset(cw.ResponseWriter)
+	}
+
+	if cw.status != 0 {
+		cw.ResponseWriter.WriteHeader(cw.status)
+	}
+
+	buffer := cw.buffer
+	cw.buffer = nil
+	if len(buffer) == 0 {
+		return nil
+	}
+
+	var err error
+	if cw.compressor != nil {
+		_, err = cw.compressor.Write(buffer)
+	} else {
+		_, err = cw.ResponseWriter.Write(buffer)
+	}
+	return err
+}
+
+// shouldCompress reports whether the response is compressed.
+func (cw *compressionResponseWriter) shouldCompress(flushed bool) bool {
+	header := cw.Header()
+	if cw.encoding == "" || (len(cw.buffer) == 0 && !flushed) {
+		return false
+	}
+	if cw.status == http.StatusNoContent || cw.status == http.StatusNotModified || cw.status == http.StatusPartialContent {
+		return false
+	}
+	if header.Get("Content-Encoding") != "" || header.Get("Content-Range") != "" ||
+		strings.Contains(header.Get("Cache-Control"), "no-transform") {
+		return false
+	}
+
+	if !flushed {
+		if len(cw.buffer) < cw.state.minSize {
+			return false
+		}
+		if length, err := strconv.Atoi(header.Get("Content-Length")); err == nil && length < cw.state.minSize {
+			return false
+		}
+	}
+
+	// net/http does not detect the type of encoded responses, so it is detected before compressing
+	if header.Get("Content-Type") == "" && len(cw.buffer) > 0 {
+		header.Set("Content-Type", http.DetectContentType(cw.buffer))
+	}
+	return cw.state.compressible(header.Get("Content-Type"))
+}
+
+// finish writes the rest of the response after the handler returned.
+func (cw *compressionResponseWriter) finish() error {
+	if cw.Hijacked() {
+		return nil
+	}
+	if !cw.decided {
+		err := cw.decide(false)
+		if err != nil {
+			return err
+		}
+	}
+	if cw.compressor == nil {
+		return nil
+	}
+
+	err := cw.compressor.Close()
+	cw.compressor.Reset(nil)
+	cw.state.pools[cw.encoding].Put(cw.compressor)
+	cw.compressor = nil
+	return err
+}
+
+func (cw *compressionResponseWriter) Flush() {
+	if !cw.decided {
+		if cw.decide(true) != nil {
+			return
+		}
+	}
+	if cw.compressor != nil && cw.compressor.Flush() != nil {
+		return
+	}
+	cw.ResponseWriter.Flush()
+}
+
+// decompressedBody closes the decompressor and the original body.
+type decompressedBody struct {
+	io.Reader
+	decompressor io.Closer
+	body         io.Closer
+}
+
+func (b *decompressedBody) Close() error {
+	return errors.Join(b.decompressor.Close(), b.body.Close())
+}
+
+// decompressRequest replaces the body of a request sent with a Content-Encoding with the decompressed body.
+func (state *compressionState) decompressRequest(w http.ResponseWriter, r *http.Request) error {
+	contentEncoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+	if contentEncoding == "" || contentEncoding == "identity" || r.Body == nil || r.Body == http.NoBody {
+		return nil
+	}
+
+	encoding, ok := compressionEncodings[contentEncoding]
+	if !ok {
+		// RFC 9110 15.5.16: unsupported content codings are answered with 415 and Accept-Encoding
+		w.Header().Set("Accept-Encoding", strings.Join(slices.Sorted(maps.Keys(compressionEncodings)), ", "))
+		return core.NewHTTPError(http.StatusUnsupportedMediaType, "unsupported_content_encoding",
+			fmt.Sprintf("the content encoding %q is not supported", contentEncoding))
+	}
+
+	decompressor, err := encoding.newDecompressor(r.Body)
+	if err != nil {
+		httpErr := core.NewHTTPError(http.StatusBadRequest, "invalid_body", "the request body could not be decompressed")
+		httpErr.Err = err
+		return httpErr
+	}
+
+	// the decompressed size is limited to protect against decompression bombs
+	r.Body = http.MaxBytesReader(w, &decompressedBody{Reader: decompressor, decompressor: decompressor, body: r.Body}, state.maxDecompressedSize)
+	r.Header.Del("Content-Encoding")
+	r.Header.Del("Content-Length")
+	r.ContentLength = -1
+	return nil
+}
+
+func compressionHandler(next http.Handler) http.Handler {
+	logger := core.MiddlewareLogger("compression")
+
+	conf, _ := core.GetMiddlewareConfig("compression")
+	state, err := newCompressionState(conf)
+	if err != nil {
+		logger.Error("invalid config, using defaults", "error", err)
+		state, _ = newCompressionState(nil)
+	}
+	compressionCurrent.Store(state)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		state := compressionCurrent.Load()
+
+		if state.decompressRequests {
+			err := state.decompressRequest(w, r)
+			if err != nil {
+				core.WriteError(w, r, err)
+				return
+			}
+		}
+
+		// the response depends on Accept-Encoding even if it is not compressed
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		cw := &compressionResponseWriter{
+			ResponseWriter: core.NewResponseWriter(w),
+			state:          state,
+			encoding:       state.negotiate(r.Header.Get("Accept-Encoding")),
+		}
+
+		next.ServeHTTP(cw, r)
+
+		err := cw.finish()
+		if err != nil {
+			logger.DebugContext(r.Context(), "failed writing compressed response", "error", err)
+		}
+	})
+}
+
+// compressionConfigChange applies the new config.
+// If the new config is invalid, the old config is kept.
+func compressionConfigChange(oldConfig, newConfig karotteapi.Config) error {
+	state, err := newCompressionState(newConfig)
+	if err != nil {
+		return err
+	}
+
+	compressionCurrent.Store(state)
+	return nil
+}
+
+func init() {
+	core.RegisterMiddleware(compressionMiddleware)
+}
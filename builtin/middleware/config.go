@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"time"
+
+	"github.com/karotte128/karotteapi"
+	"github.com/karotte128/karotteapi/core"
+)
+
+// toFloat converts a number from the config.
+// Depending on the config format, numbers are int, int64 or float64.
+func toFloat(value any) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case int64:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	}
+	return 0, false
+}
+
+// configFloat reads a number from the config.
+func configFloat(conf karotteapi.Config, defaultValue float64, path ...string) float64 {
+	value, _ := core.GetNestedValue[any](conf, path...)
+	number, ok := toFloat(value)
+	if !ok {
+		return defaultValue
+	}
+	return number
+}
+
+// configFloats reads a list of numbers from the config.
+// If the value is missing or contains other values, defaultValue is returned.
+func configFloats(conf karotteapi.Config, defaultValue []float64, path ...string) []float64 {
+	list, ok := core.GetNestedValue[[]any](conf, path...)
+	if !ok {
+		return defaultValue
+	}
+
+	numbers := make([]float64, len(list))
+	for i, value := range list {
+		numbers[i], ok = toFloat(value)
+		if !ok {
+			return defaultValue
+		}
+	}
+	return numbers
+}
+
+// configInt reads an integer from the config.
+func configInt(conf karotteapi.Config, defaultValue int64, path ...string) int64 {
+	return int64(configFloat(conf, float64(defaultValue), path...))
+}
+
+// configSeconds reads a duration in seconds from the config.
+func configSeconds(conf karotteapi.Config, defaultValue time.Duration, path ...string) time.Duration {
+	return time.Duration(configFloat(conf, defaultValue.Seconds(), path...) * float64(time.Second))
+}
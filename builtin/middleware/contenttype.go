@@ -1,28 +1,127 @@
 package middleware
 
 import (
+	"io"
 	"net/http"
+	"strings"
+	"sync/atomic"
 
 	"github.com/karotte128/karotteapi"
 	"github.com/karotte128/karotteapi/core"
 )
 
+// The contentType middleware sets the Content-Type of responses whose handler did not set one.
+// The type is detected from the first bytes of the body (like net/http does), so HTML, images
+// and other binary responses get their real type. Only bodies that are detected as plain text
+// get the configured default type. Responses without a body get no Content-Type.
+//
+// [middleware.contentType]
+// enable = true
+// default = "application/json"   # "" leaves plain text bodies to net/http
+
 var contentTypeMiddleware = karotteapi.Middleware{
-	Name:        "contentType",
-	Handler:     contentTypeHandler,
-	Priority:    2,
-	ForceEnable: false,
+	Name:           "contentType",
+	Handler:        contentTypeHandler,
+	Priority:       2,
+	ForceEnable:    false,
+	OnConfigChange: contentTypeConfigChange,
+}
+
+// contentTypeState is the config of the contentType middleware.
+type contentTypeState struct {
+	defaultType string
+}
+
+// contentTypeCurrent is the current config of the contentType middleware. It is replaced on config change.
+var contentTypeCurrent atomic.Pointer[contentTypeState]
+
+// newContentTypeState creates the contentType config from the middleware config block.
+func newContentTypeState(conf karotteapi.Config) *contentTypeState {
+	return &contentTypeState{
+		defaultType: core.GetNestedValueOrDefault(conf, "application/json", "default"),
+	}
+}
+
+// contentTypeResponseWriter delays the status line until the first write,
+// so the Content-Type can be detected from the body.
+type contentTypeResponseWriter struct {
+	*core.ResponseWriter
+	defaultType string
+
+	status      int
+	wroteHeader bool
+}
+
+func (w *contentTypeResponseWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	// informational responses are sent right away and do not end the header
+	if status < 200 {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	w.status = status
+}
+
+func (w *contentTypeResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		header := w.Header()
+		if header.Get("Content-Type") == "" && len(b) > 0 && header.Get("Content-Encoding") == "" {
+			detected := http.DetectContentType(b)
+			if strings.HasPrefix(detected, "text/plain") && w.defaultType != "" {
+				detected = w.defaultType
+			}
+			header.Set("Content-Type", detected)
+		}
+		w.writeHeader()
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// ReadFrom detects the Content-Type from the first write, the rest is passed to the wrapped writer.
+func (w *contentTypeResponseWriter) ReadFrom(src io.Reader) (int64, error) {
+	if w.wroteHeader {
+		return w.ResponseWriter.ReadFrom(src)
+	}
+	return io.Copy(struct{ io.Writer }{w}, src)
+}
+
+// writeHeader sends the delayed status line.
+func (w *contentTypeResponseWriter) writeHeader() {
+	if w.wroteHeader || w.Hijacked() {
+		return
+	}
+	w.wroteHeader = true
+	if w.status != 0 {
+		w.ResponseWriter.WriteHeader(w.status)
+	}
+}
+
+func (w *contentTypeResponseWriter) Flush() {
+	w.writeHeader()
+	w.ResponseWriter.Flush()
 }
 
 func contentTypeHandler(next http.Handler) http.Handler {
+	conf, _ := core.GetMiddlewareConfig("contentType")
+	contentTypeCurrent.Store(newContentTypeState(conf))
+
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if w.Header().Get("Content-Type") == "" {
-			w.Header().Set("Content-Type", "application/json")
-		}
-		next.ServeHTTP(w, r)
+		wrapped := &contentTypeResponseWriter{ResponseWriter: core.NewResponseWriter(w), defaultType: contentTypeCurrent.Load().defaultType}
+		next.ServeHTTP(wrapped, r)
+
+		// the handler set a status without writing a body
+		wrapped.writeHeader()
 	})
 }
 
+// contentTypeConfigChange applies the new config.
+func contentTypeConfigChange(oldConfig, newConfig karotteapi.Config) error {
+	contentTypeCurrent.Store(newContentTypeState(newConfig))
+	return nil
+}
+
 func init() {
 	core.RegisterMiddleware(contentTypeMiddleware)
 }
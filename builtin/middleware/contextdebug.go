@@ -0,0 +1,41 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/karotte128/karotteapi"
+	"github.com/karotte128/karotteapi/core"
+	"github.com/karotte128/karotteapi/internal"
+)
+
+// contextDebugMiddleware records all values the framework sets in the request context
+// and logs their keys and types after the request. It is meant for debugging and disabled by default.
+// The values are not logged, because they contain e.g. JWT claims and principals.
+// Its priority number is the largest, so it is applied last and is the outermost layer,
+// which wraps all other middleware and sees all their values.
+
+var contextDebugMiddleware = karotteapi.Middleware{
+	Name:        "contextDebug",
+	Handler:     contextDebugHandler,
+	Priority:    1000,
+	ForceEnable: false,
+}
+
+func contextDebugHandler(next http.Handler) http.Handler {
+	logger := core.MiddlewareLogger("contextDebug")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := internal.TrackRequestContext(r.Context())
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+
+		for _, value := range core.ListRequestContext(ctx) {
+			logger.InfoContext(ctx, "request context value", "method", r.Method, "path", r.URL.Path, "key", value.ContextKey, "type", fmt.Sprintf("%T", value.Info))
+		}
+	})
+}
+
+func init() {
+	core.RegisterMiddleware(contextDebugMiddleware)
+}
@@ -0,0 +1,286 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/karotte128/karotteapi"
+	"github.com/karotte128/karotteapi/core"
+)
+
+// The cors middleware handles Cross-Origin Resource Sharing for browser clients.
+//
+// [middleware.cors]
+// enable = true
+// allowed_origins = ["https://app.example.com", "https://*.example.com", "regex:^https://.*\\.example\\.dev$"]
+// allowed_methods = ["GET", "POST"]
+// allowed_headers = ["Content-Type", "Authorization"]   # "*" allows all headers
+// exposed_headers = ["RateLimit-Remaining"]
+// allow_credentials = false
+// max_age = 600                                         # seconds browsers may cache a preflight
+//
+// [middleware.cors.modules.public]   # per-module override
+// allowed_origins = ["*"]
+//
+// Overrides only need to contain the values that differ.
+
+var corsMiddleware = karotteapi.Middleware{
+	Name:           "cors",
+	Handler:        corsHandler,
+	Priority:       8,
+	ForceEnable:    false,
+	OnConfigChange: corsConfigChange,
+}
+
+// corsOrigin matches an allowed origin.
+type corsOrigin struct {
+	// any is true for "*".
+	any bool
+
+	// exact is an exact origin.
+	exact string
+
+	// scheme and suffix match wildcard subdomains ("https://*.example.com").
+	scheme string
+	suffix string
+
+	// pattern is a regular expression ("regex:...").
+	pattern *regexp.Regexp
+}
+
+// matches reports whether the origin is allowed.
+func (o corsOrigin) matches(origin string) bool {
+	switch {
+	case o.any:
+		return true
+	case o.pattern != nil:
+		return o.pattern.MatchString(origin)
+	case o.suffix != "":
+		rest, ok := strings.CutPrefix(origin, o.scheme+"://")
+		return ok && strings.HasSuffix(rest, o.suffix) && len(rest) > len(o.suffix)
+	}
+	return strings.EqualFold(o.exact, origin)
+}
+
+// corsPolicy is the CORS config of the API or a module.
+type corsPolicy struct {
+	origins          []corsOrigin
+	allowedOrigins   []string
+	methods          []string
+	headers          []string
+	exposedHeaders   []string
+	allowCredentials bool
+	maxAge           time.Duration
+}
+
+// corsState is the config of the cors middleware.
+type corsState struct {
+	global  *corsPolicy
+	modules map[string]*corsPolicy
+}
+
+// corsCurrent is the current config of the cors middleware. It is replaced on config change.
+var corsCurrent atomic.Pointer[corsState]
+
+// readCORSPolicy reads a policy from the config. Missing values are taken from base.
+func readCORSPolicy(conf karotteapi.Config, base *corsPolicy) (*corsPolicy, error) {
+	policy := &corsPolicy{
+		allowedOrigins:   core.GetNestedValueOrDefault(conf, base.allowedOrigins, "allowed_origins"),
+		methods:          core.GetNestedValueOrDefault(conf, base.methods, "allowed_methods"),
+		headers:          core.GetNestedValueOrDefault(conf, base.headers, "allowed_headers"),
+		exposedHeaders:   core.GetNestedValueOrDefault(conf, base.exposedHeaders, "exposed_headers"),
+		allowCredentials: core.GetNestedValueOrDefault(conf, base.allowCredentials, "allow_credentials"),
+		maxAge:           configSeconds(conf, base.maxAge, "max_age"),
+	}
+
+	for _, origin := range policy.allowedOrigins {
+		switch {
+		case origin == "*":
+			policy.origins = append(policy.origins, corsOrigin{any: true})
+
+		case strings.HasPrefix(origin, "regex:"):
+			pattern, err := regexp.Compile(strings.TrimPrefix(origin, "regex:"))
+			if err != nil {
+				return nil, fmt.Errorf("invalid origin pattern %q: %w", origin, err)
+			}
+			policy.origins = append(policy.origins, corsOrigin{pattern: pattern})
+
+		case strings.Contains(origin, "://*."):
+			scheme, host, _ := strings.Cut(origin, "://*")
+			policy.origins = append(policy.origins, corsOrigin{scheme: scheme, suffix: host})
+
+		default:
+			policy.origins = append(policy.origins, corsOrigin{exact: origin})
+		}
+	}
+
+	// browsers do not send credentials to "*", reflecting every origin instead would let any site read the responses
+	if policy.allowCredentials && slices.Contains(policy.allowedOrigins, "*") {
+		return nil, fmt.Errorf(`allowed_origins "*" can not be used with allow_credentials, list the origins instead`)
+	}
+
+	return policy, nil
+}
+
+// newCORSState creates the cors config from the middleware config block.
+func newCORSState(conf karotteapi.Config) (*corsState, error) {
+	defaults := &corsPolicy{
+		methods: []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE"},
+		headers: []string{"Content-Type", "Authorization"},
+	}
+
+	global, err := readCORSPolicy(conf, defaults)
+	if err != nil {
+		return nil, err
+	}
+
+	state := &corsState{global: global, modules: map[string]*corsPolicy{}}
+
+	modules, _ := core.GetNestedValue[map[string]any](conf, "modules")
+	for module, moduleConf := range modules {
+		blockConf, _ := moduleConf.(map[string]any)
+		policy, err := readCORSPolicy(blockConf, global)
+		if err != nil {
+			return nil, fmt.Errorf("module %s: %w", module, err)
+		}
+		state.modules[module] = policy
+	}
+
+	return state, nil
+}
+
+// findPolicy returns the policy of the module serving the request or the global policy.
+func (state *corsState) findPolicy(r *http.Request) *corsPolicy {
+	module, ok := core.GetModuleForRequest(r)
+	if ok {
+		policy, ok := state.modules[module]
+		if ok {
+			return policy
+		}
+	}
+	return state.global
+}
+
+// allowOrigin returns the value of the Access-Control-Allow-Origin header.
+// It returns false if the origin is not allowed.
+func (policy *corsPolicy) allowOrigin(origin string) (string, bool) {
+	for _, o := range policy.origins {
+		if !o.matches(origin) {
+			continue
+		}
+
+		if o.any {
+			return "*", true
+		}
+		return origin, true
+	}
+	return "", false
+}
+
+// allowHeaders checks the requested headers of a preflight request.
+func (policy *corsPolicy) allowHeaders(requested string) bool {
+	if slices.Contains(policy.headers, "*") {
+		return true
+	}
+
+	for header := range strings.SplitSeq(requested, ",") {
+		header = strings.TrimSpace(header)
+		if header == "" {
+			continue
+		}
+		if !slices.ContainsFunc(policy.headers, func(h string) bool { return strings.EqualFold(h, header) }) {
+			return false
+		}
+	}
+	return true
+}
+
+func corsHandler(next http.Handler) http.Handler {
+	conf, _ := core.GetMiddlewareConfig("cors")
+	state, err := newCORSState(conf)
+	if err != nil {
+		// without a valid config, no cross-origin requests are allowed
+		core.MiddlewareLogger("cors").Error("invalid config, denying all origins", "error", err)
+		state = &corsState{global: &corsPolicy{}, modules: map[string]*corsPolicy{}}
+	}
+	corsCurrent.Store(state)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		state := corsCurrent.Load()
+		header := w.Header()
+		header.Add("Vary", "Origin")
+
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		policy := state.findPolicy(r)
+		allowedOrigin, originOk := policy.allowOrigin(origin)
+
+		requestedMethod := r.Header.Get("Access-Control-Request-Method")
+		if r.Method == http.MethodOptions && requestedMethod != "" {
+			// preflight request
+			header.Add("Vary", "Access-Control-Request-Method")
+			header.Add("Vary", "Access-Control-Request-Headers")
+
+			requestedHeaders := r.Header.Get("Access-Control-Request-Headers")
+			if !originOk || !slices.Contains(policy.methods, requestedMethod) || !policy.allowHeaders(requestedHeaders) {
+				rejectRequest(w, r, core.NewHTTPError(http.StatusForbidden, "cors_rejected", "cross-origin request not allowed"))
+				return
+			}
+
+			header.Set("Access-Control-Allow-Origin", allowedOrigin)
+			header.Set("Access-Control-Allow-Methods", strings.Join(policy.methods, ", "))
+			if requestedHeaders != "" {
+				header.Set("Access-Control-Allow-Headers", requestedHeaders)
+			}
+			if policy.allowCredentials {
+				header.Set("Access-Control-Allow-Credentials", "true")
+			}
+			if policy.maxAge > 0 {
+				header.Set("Access-Control-Max-Age", strconv.Itoa(int(policy.maxAge.Seconds())))
+			}
+
+			logAnswered(w, r, func(w http.ResponseWriter) {
+				w.WriteHeader(http.StatusNoContent)
+			})
+			return
+		}
+
+		if originOk {
+			header.Set("Access-Control-Allow-Origin", allowedOrigin)
+			if policy.allowCredentials {
+				header.Set("Access-Control-Allow-Credentials", "true")
+			}
+			if len(policy.exposedHeaders) > 0 {
+				header.Set("Access-Control-Expose-Headers", strings.Join(policy.exposedHeaders, ", "))
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// corsConfigChange applies the new config.
+// If the new config is invalid, the old config is kept.
+func corsConfigChange(oldConfig, newConfig karotteapi.Config) error {
+	state, err := newCORSState(newConfig)
+	if err != nil {
+		return err
+	}
+
+	corsCurrent.Store(state)
+	return nil
+}
+
+func init() {
+	core.RegisterMiddleware(corsMiddleware)
+}
@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"testing"
+
+	"github.com/karotte128/karotteapi"
+)
+
+func TestCORSRejectsAnyOriginWithCredentials(t *testing.T) {
+	for _, conf := range []karotteapi.Config{
+		{"allowed_origins": []any{"*"}, "allow_credentials": true},
+		// the module inherits allow_credentials
+		{
+			"allowed_origins":   []any{"https://app.example.com"},
+			"allow_credentials": true,
+			"modules":           map[string]any{"public": map[string]any{"allowed_origins": []any{"*"}}},
+		},
+	} {
+		if _, err := newCORSState(conf); err == nil {
+			t.Errorf("%v: no error", conf)
+		}
+	}
+}
+
+func TestCORSAllowOrigin(t *testing.T) {
+	state, err := newCORSState(karotteapi.Config{
+		"allowed_origins":   []any{"https://app.example.com", "https://*.example.org"},
+		"allow_credentials": true,
+		"modules":           map[string]any{"public": map[string]any{"allowed_origins": []any{"*"}, "allow_credentials": false}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		policy *corsPolicy
+		origin string
+		want   string
+		ok     bool
+	}{
+		{state.global, "https://app.example.com", "https://app.example.com", true},
+		{state.global, "https://a.example.org", "https://a.example.org", true},
+		{state.global, "https://example.org", "", false},
+		{state.global, "https://evil.example", "", false},
+		// "*" is never turned into the reflected origin
+		{state.modules["public"], "https://evil.example", "*", true},
+	} {
+		got, ok := test.policy.allowOrigin(test.origin)
+		if got != test.want || ok != test.ok {
+			t.Errorf("%s: allowOrigin = %q, %v", test.origin, got, ok)
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package middleware
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/karotte128/karotteapi/core"
+)
+
+// jwtKey is a key to verify JWT signatures.
+// key is []byte (HS256), *rsa.PublicKey (RS256) or *ecdsa.PublicKey (ES256).
+type jwtKey struct {
+	kid string
+	key any
+}
+
+// supports reports whether the key can verify signatures of the algorithm.
+func (k jwtKey) supports(alg string) bool {
+	switch k.key.(type) {
+	case []byte:
+		return alg == "HS256"
+	case *rsa.PublicKey:
+		return alg == "RS256"
+	case *ecdsa.PublicKey:
+		return alg == "ES256"
+	}
+	return false
+}
+
+// jwk is a JSON Web Key as defined in RFC 7517.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+
+	// EC
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+
+	// symmetric
+	K string `json:"k"`
+}
+
+// errUnsupportedKey is returned for JWKs of a key type or curve that is not supported.
+var errUnsupportedKey = errors.New("unsupported key")
+
+// parseJWKS parses a JSON Web Key Set. Keys that are not used for signatures are skipped.
+// Keys of an unsupported type or curve (e.g. P-384 or OKP) are skipped with a log line,
+// so a key set shared with other services can still be used.
+func parseJWKS(data []byte) ([]jwtKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+
+	err := json.Unmarshal(data, &set)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []jwtKey
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		key, err := k.publicKey()
+		if errors.Is(err, errUnsupportedKey) {
+			core.MiddlewareLogger("jwt").Warn("skipping unsupported jwks key", "kid", k.Kid, "error", err)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", k.Kid, err)
+		}
+		keys = append(keys, jwtKey{kid: k.Kid, key: key})
+	}
+	return keys, nil
+}
+
+// publicKey returns the verification key of the JWK.
+func (k jwk) publicKey() (any, error) {
+	decode := base64.RawURLEncoding.DecodeString
+
+	switch k.Kty {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("%w: curve %q", errUnsupportedKey, k.Crv)
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		// ecdsa.ParseUncompressedPublicKey validates that the point is on the curve
+		point := append([]byte{4}, append(leftPad(x, 32), leftPad(y, 32)...)...)
+		return ecdsa.ParseUncompressedPublicKey(elliptic.P256(), point)
+
+	case "oct":
+		secret, err := decode(k.K)
+		if err != nil {
+			return nil, err
+		}
+		if len(secret) == 0 {
+			return nil, errors.New("empty symmetric key")
+		}
+		return secret, nil
+	}
+
+	return nil, fmt.Errorf("%w: key type %q", errUnsupportedKey, k.Kty)
+}
+
+// leftPad pads b with zeros to size bytes.
+func leftPad(b []byte, size int) []byte {
+	if len(b) >= size {
+		return b
+	}
+	return append(make([]byte, size-len(b)), b...)
+}
+
+// parseConfigKey parses a key from the config.
+// A PEM encoded public key is used for RS256 or ES256, any other value is a HS256 secret.
+func parseConfigKey(kid string, value string) (jwtKey, error) {
+	if !strings.HasPrefix(strings.TrimSpace(value), "-----BEGIN") {
+		if value == "" {
+			return jwtKey{}, errors.New("empty symmetric key")
+		}
+		return jwtKey{kid: kid, key: []byte(value)}, nil
+	}
+
+	block, _ := pem.Decode([]byte(value))
+	if block == nil {
+		return jwtKey{}, errors.New("invalid PEM")
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return jwtKey{}, err
+	}
+
+	switch key.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey:
+		return jwtKey{kid: kid, key: key}, nil
+	}
+	return jwtKey{}, fmt.Errorf("unsupported public key type %T", key)
+}
+
+// jwksFile is a JWKS file that is reloaded when it changes.
+type jwksFile struct {
+	path     string
+	interval time.Duration
+
+	lock      sync.Mutex
+	keys      []jwtKey
+	modTime   time.Time
+	lastCheck time.Time
+}
+
+// newJWKSFile loads the JWKS file. The file is checked for changes at most once per interval.
+func newJWKSFile(path string, interval time.Duration) (*jwksFile, error) {
+	file := &jwksFile{path: path, interval: interval}
+
+	err := file.load()
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// load reads and parses the file.
+func (f *jwksFile) load() error {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return err
+	}
+
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return fmt.Errorf("failed parsing %s: %w", f.path, err)
+	}
+
+	f.keys = keys
+	f.modTime = info.ModTime()
+	f.lastCheck = time.Now()
+	return nil
+}
+
+// getKeys returns the keys of the file and reloads it if it changed.
+// If reloading fails, the old keys are kept.
+func (f *jwksFile) getKeys() []jwtKey {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if time.Since(f.lastCheck) < f.interval {
+		return f.keys
+	}
+	f.lastCheck = time.Now()
+
+	info, err := os.Stat(f.path)
+	if err != nil {
+		core.MiddlewareLogger("jwt").Error("failed checking jwks file", "path", f.path, "error", err)
+		return f.keys
+	}
+
+	if info.ModTime().Equal(f.modTime) {
+		return f.keys
+	}
+
+	err = f.load()
+	if err != nil {
+		core.MiddlewareLogger("jwt").Error("failed reloading jwks file, keeping old keys", "path", f.path, "error", err)
+	} else {
+		core.MiddlewareLogger("jwt").Info("reloaded jwks file", "path", f.path)
+	}
+	return f.keys
+}
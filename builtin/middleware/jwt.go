@@ -0,0 +1,401 @@
+package middleware
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/karotte128/karotteapi"
+	"github.com/karotte128/karotteapi/core"
+)
+
+// The jwt middleware validates JSON Web Tokens from the "Authorization: Bearer <token>" header.
+// The claims are stored in the request context under "jwt" and the principal
+// (sub, roles) is stored like the auth middleware does it (see core.GetPrincipal).
+//
+// [middleware.jwt]
+// enable = true
+// algorithms = ["RS256", "ES256"]   # allowed algorithms (HS256, RS256, ES256)
+// issuer = "https://issuer.example"  # required "iss", optional
+// audience = ["my-api"]              # one of these must be in "aud", optional
+// leeway = 30                        # seconds of clock skew allowed for exp and nbf
+// required = true                    # reject requests without token
+// require_exp = true                 # reject tokens without "exp", which would never expire
+// jwks_file = "/etc/api/jwks.json"   # keys, reloaded when the file changes
+// jwks_reload = 30                   # seconds between checks for changes
+//
+// [middleware.jwt.keys]              # keys from the config, by key id
+// "key-1" = "-----BEGIN PUBLIC KEY-----..."  # PEM public key for RS256/ES256
+// "key-2" = "secret://env/JWT_SECRET"        # shared secret for HS256
+//
+// [middleware.jwt.modules.health]    # per-module override
+// required = false
+
+// jwtContextKey is the request context key of the token claims.
+const jwtContextKey = "jwt"
+
+var jwtMiddleware = karotteapi.Middleware{
+	Name:           "jwt",
+	Handler:        jwtHandler,
+	Priority:       6,
+	ForceEnable:    false,
+	OnConfigChange: jwtConfigChange,
+}
+
+// jwtState is the config of the jwt middleware.
+type jwtState struct {
+	algorithms []string
+	issuer     string
+	audience   []string
+	leeway     time.Duration
+	required   bool
+	requireExp bool
+	modules    map[string]bool
+	keys       []jwtKey
+	jwks       *jwksFile
+
+	// err is set if the config is invalid. All requests are rejected in this case.
+	err error
+}
+
+// jwtCurrent is the current config of the jwt middleware. It is replaced on config change.
+var jwtCurrent atomic.Pointer[jwtState]
+
+// newJWTState creates the jwt config from the middleware config block.
+func newJWTState(conf karotteapi.Config) (*jwtState, error) {
+	state := &jwtState{
+		issuer:     core.GetNestedValueOrDefault(conf, "", "issuer"),
+		leeway:     configSeconds(conf, 0, "leeway"),
+		required:   core.GetNestedValueOrDefault(conf, true, "required"),
+		requireExp: core.GetNestedValueOrDefault(conf, true, "require_exp"),
+		modules:    map[string]bool{},
+	}
+
+	state.algorithms = core.GetNestedValueOrDefault(conf, []string{"RS256", "ES256"}, "algorithms")
+	for _, alg := range state.algorithms {
+		if alg != "HS256" && alg != "RS256" && alg != "ES256" {
+			return nil, fmt.Errorf("unsupported algorithm %q", alg)
+		}
+	}
+
+	// audience can be a single string or a list
+	if audience, ok := core.GetNestedValue[string](conf, "audience"); ok {
+		state.audience = []string{audience}
+	} else {
+		state.audience, _ = core.GetNestedValue[[]string](conf, "audience")
+	}
+
+	keys, _ := core.GetNestedValue[map[string]any](conf, "keys")
+	for kid := range keys {
+		value, ok := core.GetNestedValue[string](keys, kid)
+		if !ok {
+			return nil, fmt.Errorf("key %s is not a string", kid)
+		}
+
+		key, err := parseConfigKey(kid, value)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", kid, err)
+		}
+		state.keys = append(state.keys, key)
+	}
+
+	if path, ok := core.GetNestedValue[string](conf, "jwks_file"); ok {
+		jwks, err := newJWKSFile(path, configSeconds(conf, 30*time.Second, "jwks_reload"))
+		if err != nil {
+			return nil, err
+		}
+		state.jwks = jwks
+	}
+
+	if len(state.keys) == 0 && state.jwks == nil {
+		return nil, errors.New("no keys configured")
+	}
+
+	modules, _ := core.GetNestedValue[map[string]any](conf, "modules")
+	for module := range modules {
+		required, ok := core.GetNestedValue[bool](modules, module, "required")
+		if ok {
+			state.modules[module] = required
+		}
+	}
+
+	return state, nil
+}
+
+// isRequired reports whether the request must contain a valid token.
+func (state *jwtState) isRequired(r *http.Request) bool {
+	module, ok := core.GetModuleForRequest(r)
+	if ok {
+		required, ok := state.modules[module]
+		if ok {
+			return required
+		}
+	}
+	return state.required
+}
+
+// findKey returns the key to verify the token.
+// If the token has no key id, the only key supporting the algorithm is used.
+func (state *jwtState) findKey(kid string, alg string) (jwtKey, error) {
+	keys := state.keys
+	if state.jwks != nil {
+		keys = append(slices.Clone(keys), state.jwks.getKeys()...)
+	}
+
+	var candidates []jwtKey
+	for _, key := range keys {
+		if !key.supports(alg) {
+			continue
+		}
+		if kid != "" && key.kid == kid {
+			return key, nil
+		}
+		candidates = append(candidates, key)
+	}
+
+	if kid == "" && len(candidates) == 1 {
+		return candidates[0], nil
+	}
+	return jwtKey{}, errors.New("no matching key")
+}
+
+// validate verifies the token signature and claims and returns the claims.
+func (state *jwtState) validate(token string) (map[string]any, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	err := decodeSegment(parts[0], &header)
+	if err != nil {
+		return nil, errors.New("malformed token header")
+	}
+
+	if !slices.Contains(state.algorithms, header.Alg) {
+		return nil, fmt.Errorf("algorithm %q is not allowed", header.Alg)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed token signature")
+	}
+
+	key, err := state.findKey(header.Kid, header.Alg)
+	if err != nil {
+		return nil, err
+	}
+
+	err = verifySignature(header.Alg, key.key, parts[0]+"."+parts[1], signature)
+	if err != nil {
+		return nil, err
+	}
+
+	var claims map[string]any
+	err = decodeSegment(parts[1], &claims)
+	if err != nil {
+		return nil, errors.New("malformed token claims")
+	}
+
+	err = state.validateClaims(claims, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+// validateClaims checks exp, nbf, iss and aud.
+func (state *jwtState) validateClaims(claims map[string]any, now time.Time) error {
+	if exp, ok := claims["exp"].(float64); ok {
+		if now.After(time.Unix(int64(exp), 0).Add(state.leeway)) {
+			return errors.New("token is expired")
+		}
+	} else if _, present := claims["exp"]; present {
+		return errors.New("malformed exp claim")
+	} else if state.requireExp {
+		return errors.New("token has no exp claim")
+	}
+
+	if nbf, ok := claims["nbf"].(float64); ok {
+		if now.Add(state.leeway).Before(time.Unix(int64(nbf), 0)) {
+			return errors.New("token is not valid yet")
+		}
+	} else if _, present := claims["nbf"]; present {
+		return errors.New("malformed nbf claim")
+	}
+
+	if state.issuer != "" {
+		iss, _ := claims["iss"].(string)
+		if iss != state.issuer {
+			return errors.New("invalid issuer")
+		}
+	}
+
+	if len(state.audience) > 0 {
+		var audience []string
+		switch aud := claims["aud"].(type) {
+		case string:
+			audience = []string{aud}
+		case []any:
+			for _, a := range aud {
+				if s, ok := a.(string); ok {
+					audience = append(audience, s)
+				}
+			}
+		}
+
+		if !slices.ContainsFunc(state.audience, func(a string) bool { return slices.Contains(audience, a) }) {
+			return errors.New("invalid audience")
+		}
+	}
+
+	return nil
+}
+
+// decodeSegment decodes a base64url encoded JSON token segment.
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// verifySignature verifies the signature of the signed part of the token.
+func verifySignature(alg string, key any, signed string, signature []byte) error {
+	hash := sha256.Sum256([]byte(signed))
+
+	switch alg {
+	case "HS256":
+		mac := hmac.New(sha256.New, key.([]byte))
+		mac.Write([]byte(signed))
+		if hmac.Equal(mac.Sum(nil), signature) {
+			return nil
+		}
+
+	case "RS256":
+		if rsa.VerifyPKCS1v15(key.(*rsa.PublicKey), crypto.SHA256, hash[:], signature) == nil {
+			return nil
+		}
+
+	case "ES256":
+		// the signature is r and s, 32 bytes each
+		if len(signature) == 64 {
+			r := new(big.Int).SetBytes(signature[:32])
+			s := new(big.Int).SetBytes(signature[32:])
+			if ecdsa.Verify(key.(*ecdsa.PublicKey), hash[:], r, s) {
+				return nil
+			}
+		}
+	}
+
+	return errors.New("invalid signature")
+}
+
+// principalFromClaims creates the principal from the token claims.
+// The roles are read from the "roles" claim or the space separated "scope" claim.
+func principalFromClaims(claims map[string]any) *karotteapi.Principal {
+	principal := &karotteapi.Principal{
+		Attributes: claims,
+		Method:     "jwt",
+	}
+
+	principal.ID, _ = claims["sub"].(string)
+
+	if roles, ok := claims["roles"].([]any); ok {
+		for _, role := range roles {
+			if s, ok := role.(string); ok {
+				principal.Roles = append(principal.Roles, s)
+			}
+		}
+	} else if scope, ok := claims["scope"].(string); ok {
+		principal.Roles = strings.Fields(scope)
+	}
+
+	return principal
+}
+
+// rejectJWT writes a 401 response with the bearer challenge.
+// The response does not say why the token is invalid, the cause is only logged.
+func rejectJWT(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+	rejectRequest(w, r, core.NewHTTPError(http.StatusUnauthorized, "invalid_token", "invalid token"))
+}
+
+func jwtHandler(next http.Handler) http.Handler {
+	conf, _ := core.GetMiddlewareConfig("jwt")
+	state, err := newJWTState(conf)
+	if err != nil {
+		core.MiddlewareLogger("jwt").Error("invalid config, rejecting all requests", "error", err)
+		state = &jwtState{err: err}
+	}
+	jwtCurrent.Store(state)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		state := jwtCurrent.Load()
+
+		if state.err != nil {
+			rejectRequest(w, r, core.NewHTTPError(http.StatusInternalServerError, "token_validation_unavailable", "token validation is not available"))
+			return
+		}
+
+		token, ok := bearerToken(r)
+		if !ok {
+			if state.isRequired(r) {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				rejectRequest(w, r, core.NewHTTPError(http.StatusUnauthorized, "token_required", "token required"))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		claims, err := state.validate(token)
+		if err != nil {
+			core.MiddlewareLogger("jwt").InfoContext(r.Context(), "request rejected", "method", r.Method, "path", r.URL.Path, "error", err)
+			rejectJWT(w, r)
+			return
+		}
+
+		ctx := core.SetRequestContext(r.Context(), &karotteapi.RequestContext{
+			Info:       claims,
+			ContextKey: jwtContextKey,
+		})
+		ctx = core.SetPrincipal(ctx, principalFromClaims(claims))
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// jwtConfigChange rebuilds the keys when the config changed.
+// If the new config is invalid, the old config is kept.
+func jwtConfigChange(oldConfig, newConfig karotteapi.Config) error {
+	state, err := newJWTState(newConfig)
+	if err != nil {
+		return err
+	}
+
+	jwtCurrent.Store(state)
+	return nil
+}
+
+func init() {
+	core.RegisterMiddleware(jwtMiddleware)
+}
@@ -0,0 +1,383 @@
+package middleware
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/karotte128/karotteapi"
+)
+
+// jwtTestKeys are the keys the test tokens are signed with.
+type jwtTestKeys struct {
+	secret []byte
+	rsa    *rsa.PrivateKey
+	ec     *ecdsa.PrivateKey
+}
+
+func newJWTTestKeys(t *testing.T) jwtTestKeys {
+	t.Helper()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return jwtTestKeys{secret: []byte("test secret"), rsa: rsaKey, ec: ecKey}
+}
+
+// config returns the jwt config with the keys "hs", "rs" and "es".
+func (keys jwtTestKeys) config(t *testing.T) karotteapi.Config {
+	return karotteapi.Config{
+		"algorithms": []any{"HS256", "RS256", "ES256"},
+		"leeway":     int64(30),
+		"keys": map[string]any{
+			"hs": string(keys.secret),
+			"rs": publicKeyPEM(t, &keys.rsa.PublicKey),
+			"es": publicKeyPEM(t, &keys.ec.PublicKey),
+		},
+	}
+}
+
+func publicKeyPEM(t *testing.T, key any) string {
+	t.Helper()
+
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+// signJWT creates a token. key is []byte (HS256), *rsa.PrivateKey (RS256),
+// *ecdsa.PrivateKey (ES256) or nil (none).
+func signJWT(t *testing.T, alg string, kid string, key any, claims map[string]any) string {
+	t.Helper()
+
+	header := map[string]any{"alg": alg, "typ": "JWT"}
+	if kid != "" {
+		header["kid"] = kid
+	}
+	signed := encodeJWTSegment(t, header) + "." + encodeJWTSegment(t, claims)
+	hash := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	switch key := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+
+	case *rsa.PrivateKey:
+		var err error
+		signature, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hash[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, key, hash[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func encodeJWTSegment(t *testing.T, v any) string {
+	t.Helper()
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func newTestJWTState(t *testing.T, conf karotteapi.Config) *jwtState {
+	t.Helper()
+
+	state, err := newJWTState(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return state
+}
+
+func TestJWTAlgorithms(t *testing.T) {
+	keys := newJWTTestKeys(t)
+	state := newTestJWTState(t, keys.config(t))
+
+	for _, test := range []struct {
+		alg string
+		kid string
+		key any
+	}{
+		{"HS256", "hs", keys.secret},
+		{"RS256", "rs", keys.rsa},
+		{"ES256", "es", keys.ec},
+		// without kid the only key of the algorithm is used
+		{"ES256", "", keys.ec},
+	} {
+		token := signJWT(t, test.alg, test.kid, test.key, map[string]any{"sub": "alice", "exp": time.Now().Unix() + 60})
+
+		claims, err := state.validate(token)
+		if err != nil {
+			t.Errorf("%s %q: %v", test.alg, test.kid, err)
+			continue
+		}
+		if claims["sub"] != "alice" {
+			t.Errorf("%s: claims = %v", test.alg, claims)
+		}
+	}
+}
+
+func TestJWTExpiryAndNotBeforeLeeway(t *testing.T) {
+	keys := newJWTTestKeys(t)
+	state := newTestJWTState(t, keys.config(t)) // leeway 30 seconds
+	now := time.Now().Unix()
+
+	for _, test := range []struct {
+		name   string
+		claims map[string]any
+		valid  bool
+	}{
+		{"valid", map[string]any{"exp": now + 60, "nbf": now - 60}, true},
+		{"expired within leeway", map[string]any{"exp": now - 10}, true},
+		{"expired", map[string]any{"exp": now - 60}, false},
+		{"not valid yet within leeway", map[string]any{"exp": now + 60, "nbf": now + 10}, true},
+		{"not valid yet", map[string]any{"exp": now + 60, "nbf": now + 60}, false},
+		{"malformed exp", map[string]any{"exp": "tomorrow"}, false},
+		{"no exp", map[string]any{"sub": "alice"}, false},
+	} {
+		_, err := state.validate(signJWT(t, "HS256", "hs", keys.secret, test.claims))
+		if (err == nil) != test.valid {
+			t.Errorf("%s: err = %v", test.name, err)
+		}
+	}
+}
+
+func TestJWTRequireExp(t *testing.T) {
+	keys := newJWTTestKeys(t)
+	conf := keys.config(t)
+	conf["require_exp"] = false
+	state := newTestJWTState(t, conf)
+
+	if _, err := state.validate(signJWT(t, "HS256", "hs", keys.secret, map[string]any{"sub": "alice"})); err != nil {
+		t.Errorf("token without exp: %v", err)
+	}
+	if _, err := state.validate(signJWT(t, "HS256", "hs", keys.secret, map[string]any{"exp": time.Now().Unix() - 60})); err == nil {
+		t.Error("expired token was accepted")
+	}
+}
+
+func TestJWTRejectsEmptySymmetricKeys(t *testing.T) {
+	if _, err := newJWTState(karotteapi.Config{"algorithms": []any{"HS256"}, "keys": map[string]any{"hs": ""}}); err == nil {
+		t.Error("empty config key was accepted")
+	}
+
+	for _, k := range []string{`"k": ""`, `"kid": "x"`} {
+		var key jwk
+		if err := json.Unmarshal([]byte(`{"kty": "oct", `+k+`}`), &key); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := key.publicKey(); err == nil {
+			t.Errorf("%s: empty oct key was accepted", k)
+		}
+	}
+}
+
+func TestJWTRejectsInvalidTokens(t *testing.T) {
+	keys := newJWTTestKeys(t)
+	state := newTestJWTState(t, keys.config(t))
+
+	conf := keys.config(t)
+	conf["algorithms"] = []any{"RS256"}
+	rsOnly := newTestJWTState(t, conf)
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := map[string]any{"sub": "alice", "exp": time.Now().Unix() + 60}
+
+	// the claims of a valid token replaced
+	parts := strings.Split(signJWT(t, "ES256", "es", keys.ec, claims), ".")
+	parts[1] = encodeJWTSegment(t, map[string]any{"sub": "admin"})
+	tampered := strings.Join(parts, ".")
+
+	for _, test := range []struct {
+		name  string
+		state *jwtState
+		token string
+	}{
+		{"none", state, signJWT(t, "none", "", nil, claims)},
+		{"none with kid", state, signJWT(t, "none", "hs", nil, claims)},
+		{"algorithm not allowed", rsOnly, signJWT(t, "HS256", "hs", keys.secret, claims)},
+		// the public RSA key used as HMAC secret
+		{"wrong algorithm for key", state, signJWT(t, "HS256", "rs", []byte(publicKeyPEM(t, &keys.rsa.PublicKey)), claims)},
+		{"unknown kid", state, signJWT(t, "RS256", "unknown", keys.rsa, claims)},
+		{"wrong key", state, signJWT(t, "RS256", "rs", otherKey, claims)},
+		{"tampered claims", state, tampered},
+		{"malformed", state, "not.a.token"},
+	} {
+		_, err := test.state.validate(test.token)
+		if err == nil {
+			t.Errorf("%s: token was accepted", test.name)
+		}
+	}
+}
+
+func TestJWTRejectionHidesCause(t *testing.T) {
+	keys := newJWTTestKeys(t)
+
+	handler := jwtHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("the handler was called")
+	}))
+	jwtCurrent.Store(newTestJWTState(t, keys.config(t)))
+
+	token := signJWT(t, "HS256", "hs", keys.secret, map[string]any{"exp": time.Now().Unix() - 3600})
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d", w.Code)
+	}
+	if got := w.Header().Get("WWW-Authenticate"); got != `Bearer error="invalid_token"` {
+		t.Errorf("WWW-Authenticate = %q", got)
+	}
+	if body := w.Body.String(); strings.Contains(body, "expired") || !strings.Contains(body, "invalid_token") {
+		t.Errorf("body = %s", body)
+	}
+}
+
+// rsaJWK returns the JWK of the public RSA key.
+func rsaJWK(kid string, key *rsa.PublicKey) map[string]any {
+	return map[string]any{
+		"kty": "RSA",
+		"kid": kid,
+		"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+func writeJWKS(t *testing.T, path string, keys ...map[string]any) {
+	t.Helper()
+
+	data, err := json.Marshal(map[string]any{"keys": keys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(path, data, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseJWKSSkipsUnsupportedKeys(t *testing.T) {
+	keys := newJWTTestKeys(t)
+
+	data, err := json.Marshal(map[string]any{"keys": []map[string]any{
+		{"kty": "EC", "kid": "p384", "crv": "P-384", "x": "AA", "y": "AA"},
+		{"kty": "OKP", "kid": "ed", "crv": "Ed25519", "x": "AA"},
+		{"kty": "RSA", "kid": "enc", "use": "enc"},
+		rsaJWK("rs", &keys.rsa.PublicKey),
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := parseJWKS(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parsed) != 1 || parsed[0].kid != "rs" {
+		t.Errorf("keys = %v", parsed)
+	}
+
+	// a supported key that is malformed is still an error
+	_, err = parseJWKS([]byte(`{"keys": [{"kty": "RSA", "kid": "bad", "n": "!", "e": "AQAB"}]}`))
+	if err == nil {
+		t.Error("malformed key was accepted")
+	}
+}
+
+func TestJWKSFileReload(t *testing.T) {
+	keys := newJWTTestKeys(t)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	writeJWKS(t, path, rsaJWK("old", &keys.rsa.PublicKey))
+
+	state := newTestJWTState(t, karotteapi.Config{
+		"algorithms":  []any{"RS256"},
+		"jwks_file":   path,
+		"jwks_reload": int64(0),
+	})
+
+	newKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := map[string]any{"sub": "alice", "exp": time.Now().Unix() + 60}
+	oldToken := signJWT(t, "RS256", "old", keys.rsa, claims)
+	newToken := signJWT(t, "RS256", "new", newKey, claims)
+
+	if _, err := state.validate(oldToken); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := state.validate(newToken); err == nil {
+		t.Fatal("token of an unknown key was accepted")
+	}
+
+	// rotate the key, the modification time must change for the file to be reloaded
+	writeJWKS(t, path, rsaJWK("new", &newKey.PublicKey))
+	future := time.Now().Add(time.Minute)
+	err = os.Chtimes(path, future, future)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := state.validate(newToken); err != nil {
+		t.Errorf("new key: %v", err)
+	}
+	if _, err := state.validate(oldToken); err == nil {
+		t.Error("token of the removed key was accepted")
+	}
+
+	// a broken file keeps the old keys
+	err = os.WriteFile(path, []byte("{"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	future = future.Add(time.Minute)
+	err = os.Chtimes(path, future, future)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := state.validate(newToken); err != nil {
+		t.Errorf("after broken reload: %v", err)
+	}
+}
@@ -0,0 +1,116 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/karotte128/karotteapi/core"
+)
+
+// rotatingFile is a log file that is rotated when it reaches maxSize bytes.
+// The rotated files are named <path>.1 (newest) to <path>.<maxBackups> (oldest).
+type rotatingFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	lock   sync.Mutex
+	file   *os.File
+	size   int64
+	closed bool
+}
+
+// openRotatingFile opens the log file for appending. A maxSize of 0 disables rotation.
+func openRotatingFile(path string, maxSize int64, maxBackups int) (*rotatingFile, error) {
+	f := &rotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups}
+
+	err := f.open()
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// open opens the file and reads its current size.
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+// rotate moves the current file to <path>.1 and opens a new file.
+// If moving fails, writing continues in the current file.
+func (f *rotatingFile) rotate() error {
+	f.file.Close()
+	f.file = nil
+
+	var err error
+	if f.maxBackups > 0 {
+		for i := f.maxBackups - 1; i >= 1; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", f.path, i), fmt.Sprintf("%s.%d", f.path, i+1))
+		}
+		err = os.Rename(f.path, f.path+".1")
+	} else {
+		err = os.Remove(f.path)
+	}
+
+	return errors.Join(err, f.open())
+}
+
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+
+	// the file is reopened if opening it failed on the last rotation
+	if f.file == nil {
+		err := f.open()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		err := f.rotate()
+		if err != nil {
+			core.MiddlewareLogger("logging").Error("failed rotating log file", "path", f.path, "error", err)
+			if f.file == nil {
+				return 0, err
+			}
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *rotatingFile) Close() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.closed = true
+	if f.file == nil {
+		return nil
+	}
+
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
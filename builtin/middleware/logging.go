@@ -1,79 +1,515 @@
 package middleware
 
 import (
-	"bufio"
+	"encoding/json"
 	"fmt"
-	"log"
-	"net"
+	"io"
+	"math/rand/v2"
 	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
 
 	"github.com/karotte128/karotteapi"
 	"github.com/karotte128/karotteapi/core"
 )
 
+// The logging middleware writes an access log entry for each request.
+//
+// [middleware.logging]
+// enable = true
+// format = "slog"                  # slog, combined, json, logfmt or template
+// template = "{method} {path} {status} {duration}"   # used by the template format
+// exclude_paths = ["/health"]      # paths ending in "/" exclude all paths below them
+// sample_rate = 1.0                # fraction of requests that are logged, errors (status >= 400) are always logged
+// trusted_proxies = ["10.0.0.0/8"]  # proxies whose X-Forwarded-For header is used for the client ip
+// output = "stderr"                # stderr, stdout or a file path, not used by the slog format
+// max_size = 100                   # megabytes before the file is rotated, 0 disables rotation
+// max_backups = 5                  # rotated files that are kept
+//
+// The slog format logs through the framework logger (see core.MiddlewareLogger).
+//
+// The middleware runs inside the auth, jwt, authorization, ratelimit and cors middleware.
+// Requests they answer themselves (e.g. 401, 403, 429 or a preflight) are logged through
+// logAnswered, so they are in the access log as well.
+
 var loggingMiddleware = karotteapi.Middleware{
-	Name:        "logging",
-	Handler:     loggingHandler,
-	Priority:    1,
-	ForceEnable: false,
+	Name:           "logging",
+	Handler:        loggingHandler,
+	Priority:       1,
+	ForceEnable:    false,
+	OnConfigChange: loggingConfigChange,
+}
+
+// accessEntry is the access log entry of a request.
+type accessEntry struct {
+	Time      time.Time     `json:"time"`
+	RemoteIP  string        `json:"remote_ip"`
+	Method    string        `json:"method"`
+	Path      string        `json:"path"`
+	Query     string        `json:"query,omitempty"`
+	Proto     string        `json:"proto"`
+	Status    int           `json:"status"`
+	Bytes     int64         `json:"bytes"`
+	Duration  time.Duration `json:"-"`
+	Latency   float64       `json:"duration_ms"`
+	UserAgent string        `json:"user_agent,omitempty"`
+	Referer   string        `json:"referer,omitempty"`
+	RequestID string        `json:"request_id,omitempty"`
+}
+
+// accessFields are the fields of an access log entry, in the order they are written by the logfmt format.
+var accessFields = []string{"time", "remote_ip", "method", "path", "query", "proto", "status", "bytes", "duration", "duration_ms", "user_agent", "referer", "request_id"}
+
+// field returns a field of the entry as string.
+func (e *accessEntry) field(name string) string {
+	switch name {
+	case "time":
+		return e.Time.Format(time.RFC3339)
+	case "remote_ip":
+		return e.RemoteIP
+	case "method":
+		return e.Method
+	case "path":
+		return e.Path
+	case "query":
+		return e.Query
+	case "proto":
+		return e.Proto
+	case "status":
+		return strconv.Itoa(e.Status)
+	case "bytes":
+		return strconv.FormatInt(e.Bytes, 10)
+	case "duration":
+		return e.Duration.String()
+	case "duration_ms":
+		return strconv.FormatFloat(e.Latency, 'f', 3, 64)
+	case "user_agent":
+		return e.UserAgent
+	case "referer":
+		return e.Referer
+	case "request_id":
+		return e.RequestID
+	}
+	return ""
+}
+
+// accessFormatter formats an access log line, including the newline.
+type accessFormatter func(e *accessEntry) []byte
+
+// orDash returns "-" for empty values, like Apache does.
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// formatCombined formats the entry in the Apache combined log format.
+func formatCombined(e *accessEntry) []byte {
+	uri := e.Path
+	if e.Query != "" {
+		uri += "?" + e.Query
+	}
+
+	return fmt.Appendf(nil, "%s - - [%s] %q %d %d %q %q\n",
+		e.RemoteIP,
+		e.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		e.Method+" "+uri+" "+e.Proto,
+		e.Status,
+		e.Bytes,
+		orDash(e.Referer),
+		orDash(e.UserAgent),
+	)
+}
+
+// formatJSON formats the entry as JSON object.
+func formatJSON(e *accessEntry) []byte {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return nil
+	}
+	return append(line, '\n')
+}
+
+// formatLogfmt formats the entry as key=value pairs. Empty fields are omitted.
+func formatLogfmt(e *accessEntry) []byte {
+	var line []byte
+	for _, name := range accessFields {
+		value := e.field(name)
+		if value == "" {
+			continue
+		}
+
+		if len(line) > 0 {
+			line = append(line, ' ')
+		}
+		line = append(line, name...)
+		line = append(line, '=')
+		if strings.ContainsFunc(value, needsQuoting) {
+			line = strconv.AppendQuote(line, value)
+		} else {
+			line = append(line, value...)
+		}
+	}
+	return append(line, '\n')
+}
+
+// needsQuoting reports whether a logfmt value containing the rune must be quoted.
+func needsQuoting(r rune) bool {
+	return r <= ' ' || r == '"' || r == '=' || r == '\\' || !unicode.IsPrint(r)
+}
+
+// parseTemplate parses a template with {field} placeholders.
+func parseTemplate(template string) (accessFormatter, error) {
+	// parts alternates between literal text and field names
+	var parts []string
+	rest := template
+	for {
+		start := strings.IndexByte(rest, '{')
+		if start < 0 {
+			parts = append(parts, rest)
+			break
+		}
+
+		end := strings.IndexByte(rest[start:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("unclosed placeholder in template %q", template)
+		}
+
+		name := rest[start+1 : start+end]
+		if !slices.Contains(accessFields, name) {
+			return nil, fmt.Errorf("unknown field %q in template", name)
+		}
+
+		parts = append(parts, rest[:start], name)
+		rest = rest[start+end+1:]
+	}
+
+	return func(e *accessEntry) []byte {
+		var line []byte
+		for i, part := range parts {
+			if i%2 == 0 {
+				line = append(line, part...)
+			} else {
+				line = append(line, orDash(e.field(part))...)
+			}
+		}
+		return append(line, '\n')
+	}, nil
+}
+
+// loggingState is the config of the logging middleware.
+type loggingState struct {
+	// formatter is nil for the slog format.
+	formatter    accessFormatter
+	excludePaths []string
+	sampleRate   float64
+	proxies      trustedProxies
+
+	output string
+	// out is nil for the slog format.
+	out *accessOutput
+}
+
+// accessOutput is the destination of the access log lines. It is shared by the states of all
+// configs with the same output. After a config change with another output, the old output is
+// retired and only closed once the last request that uses it was logged.
+type accessOutput struct {
+	writer io.Writer
+	closer io.Closer
+
+	// lock serializes writes, so lines of concurrent requests are not mixed.
+	// It also guards users, retired and closed.
+	lock    sync.Mutex
+	users   int
+	retired bool
+	closed  bool
+}
+
+// acquire marks the output as used by a request. It returns false if the output is already closed.
+func (o *accessOutput) acquire() bool {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	if o.closed {
+		return false
+	}
+	o.users++
+	return true
+}
+
+// release ends the use by a request. A retired output is closed by its last request.
+func (o *accessOutput) release() {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	o.users--
+	o.closeIfUnused()
+}
+
+// retire marks the output as replaced. It is closed as soon as no request uses it.
+func (o *accessOutput) retire() {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	o.retired = true
+	o.closeIfUnused()
+}
+
+// closeIfUnused closes a retired output that is not used anymore. The caller must hold the lock.
+func (o *accessOutput) closeIfUnused() {
+	if !o.retired || o.users > 0 || o.closed {
+		return
+	}
+
+	o.closed = true
+	if o.closer != nil {
+		err := o.closer.Close()
+		if err != nil {
+			core.MiddlewareLogger("logging").Error("failed closing access log", "error", err)
+		}
+	}
+}
+
+// write writes a line to the output.
+func (o *accessOutput) write(line []byte) error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	_, err := o.writer.Write(line)
+	return err
 }
 
-// loggingResponseWriter wraps http.ResponseWriter so we can capture
-// the status code and response size written by handlers.
-type loggingResponseWriter struct {
-	http.ResponseWriter
-	statusCode int
-	size       int
+// loggingCurrent is the current config of the logging middleware. It is replaced on config change.
+var loggingCurrent atomic.Pointer[loggingState]
+
+// newLoggingState creates the logging config from the middleware config block.
+// The output of the old state is reused if it did not change.
+func newLoggingState(conf karotteapi.Config, old *loggingState) (*loggingState, error) {
+	state := &loggingState{
+		excludePaths: core.GetNestedValueOrDefault(conf, []string{}, "exclude_paths"),
+		sampleRate:   configFloat(conf, 1, "sample_rate"),
+		output:       core.GetNestedValueOrDefault(conf, "stderr", "output"),
+	}
+
+	if state.sampleRate < 0 || state.sampleRate > 1 {
+		return nil, fmt.Errorf("sample_rate must be between 0 and 1, got %v", state.sampleRate)
+	}
+
+	proxies, err := readTrustedProxies(conf)
+	if err != nil {
+		return nil, err
+	}
+	state.proxies = proxies
+
+	switch format := core.GetNestedValueOrDefault(conf, "slog", "format"); format {
+	case "slog":
+		return state, nil
+	case "combined":
+		state.formatter = formatCombined
+	case "json":
+		state.formatter = formatJSON
+	case "logfmt":
+		state.formatter = formatLogfmt
+	case "template":
+		template, ok := core.GetNestedValue[string](conf, "template")
+		if !ok {
+			return nil, fmt.Errorf("the template format requires a template")
+		}
+		formatter, err := parseTemplate(template)
+		if err != nil {
+			return nil, err
+		}
+		state.formatter = formatter
+	default:
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+
+	if old != nil && old.out != nil && old.output == state.output {
+		state.out = old.out
+		return state, nil
+	}
+
+	switch state.output {
+	case "stderr":
+		state.out = &accessOutput{writer: os.Stderr}
+	case "stdout":
+		state.out = &accessOutput{writer: os.Stdout}
+	default:
+		file, err := openRotatingFile(state.output,
+			int64(configFloat(conf, 100, "max_size")*(1<<20)),
+			int(configInt(conf, 5, "max_backups")),
+		)
+		if err != nil {
+			return nil, err
+		}
+		state.out = &accessOutput{writer: file, closer: file}
+	}
+
+	return state, nil
 }
 
-// Track status code
-func (lrw *loggingResponseWriter) WriteHeader(code int) {
-	lrw.statusCode = code
-	lrw.ResponseWriter.WriteHeader(code)
+// excluded reports whether requests to the path are not logged.
+func (state *loggingState) excluded(path string) bool {
+	for _, excluded := range state.excludePaths {
+		if path == excluded || (strings.HasSuffix(excluded, "/") && strings.HasPrefix(path, excluded)) {
+			return true
+		}
+	}
+	return false
 }
 
-// Track response size
-func (lrw *loggingResponseWriter) Write(b []byte) (int, error) {
-	size, err := lrw.ResponseWriter.Write(b)
-	lrw.size += size
-	return size, err
+// sampled reports whether the request is logged. Errors are always logged.
+func (state *loggingState) sampled(status int) bool {
+	return status >= 400 || state.sampleRate >= 1 || rand.Float64() < state.sampleRate
 }
 
-// Required for WebSocket upgrade
-func (lrw *loggingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
-	hijacker, ok := lrw.ResponseWriter.(http.Hijacker)
-	if !ok {
-		return nil, nil, fmt.Errorf("underlying ResponseWriter does not support Hijacker")
+// acquireLoggingState returns the current config and marks its output as used,
+// so the output is not closed by a config change before the request is logged.
+func acquireLoggingState() *loggingState {
+	for {
+		state := loggingCurrent.Load()
+		if state.out == nil || state.out.acquire() {
+			return state
+		}
+		// the output was closed after a config change, the new config has another output
 	}
-	return hijacker.Hijack()
 }
 
-// Required for streaming / websocket flushing
-func (lrw *loggingResponseWriter) Flush() {
-	if flusher, ok := lrw.ResponseWriter.(http.Flusher); ok {
-		flusher.Flush()
+// release ends the use of the output by a request.
+func (state *loggingState) release() {
+	if state.out != nil {
+		state.out.release()
 	}
 }
 
-// loggingMiddleware logs method, path, status, and response size.
+// loggingMiddleware logs method, path, status, response size and duration.
 func loggingHandler(next http.Handler) http.Handler {
+	logger := core.MiddlewareLogger("logging")
+
+	conf, _ := core.GetMiddlewareConfig("logging")
+	state, err := newLoggingState(conf, nil)
+	if err != nil {
+		logger.Error("invalid config, using defaults", "error", err)
+		state, _ = newLoggingState(nil, nil)
+	}
+	loggingCurrent.Store(state)
+
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		lrw := &loggingResponseWriter{
-			ResponseWriter: w,
-			statusCode:     http.StatusOK, // default, in case WriteHeader is never called
+		state := acquireLoggingState()
+		defer state.release()
+
+		if state.excluded(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
 		}
 
-		next.ServeHTTP(lrw, r)
+		rw := core.NewResponseWriter(w)
 
-		log.Printf("[LOG] %s %s -> %d (%d bytes)",
-			r.Method,
-			r.URL.Path,
-			lrw.statusCode,
-			lrw.size,
-		)
+		next.ServeHTTP(rw, r)
+
+		state.log(r, rw)
 	})
 }
 
+// logAnswered writes the response of a middleware that runs outside the logging middleware
+// and answers the request itself, and writes the access log entry of it.
+// If respond writes no response, nothing is logged. Without the logging middleware,
+// the response is only written.
+func logAnswered(w http.ResponseWriter, r *http.Request, respond func(w http.ResponseWriter)) {
+	if loggingCurrent.Load() == nil {
+		respond(w)
+		return
+	}
+
+	state := acquireLoggingState()
+	defer state.release()
+
+	rw := core.NewResponseWriter(w)
+	respond(rw)
+
+	if rw.Written() && !state.excluded(r.URL.Path) {
+		state.log(r, rw)
+	}
+}
+
+// rejectRequest writes the error response and logs the request, see logAnswered.
+func rejectRequest(w http.ResponseWriter, r *http.Request, err error) {
+	logAnswered(w, r, func(w http.ResponseWriter) {
+		core.WriteError(w, r, err)
+	})
+}
+
+// log writes the access log entry of the response written by rw.
+func (state *loggingState) log(r *http.Request, rw *core.ResponseWriter) {
+	logger := core.MiddlewareLogger("logging")
+
+	duration := rw.Duration()
+	if !state.sampled(rw.Status()) {
+		return
+	}
+
+	if state.formatter == nil {
+		logger.InfoContext(r.Context(), "request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rw.Status(),
+			"bytes", rw.Bytes(),
+			"duration", duration,
+			"remote_ip", clientIP(r, state.proxies),
+		)
+		return
+	}
+
+	entry := &accessEntry{
+		Time:      rw.Start(),
+		RemoteIP:  clientIP(r, state.proxies),
+		Method:    r.Method,
+		Path:      r.URL.Path,
+		Query:     r.URL.RawQuery,
+		Proto:     r.Proto,
+		Status:    rw.Status(),
+		Bytes:     rw.Bytes(),
+		Duration:  duration,
+		Latency:   float64(duration.Microseconds()) / 1000,
+		UserAgent: r.UserAgent(),
+		Referer:   r.Referer(),
+		RequestID: core.GetRequestID(r.Context()),
+	}
+
+	line := state.formatter(entry)
+
+	err := state.out.write(line)
+	if err != nil {
+		logger.Error("failed writing access log", "error", err)
+	}
+}
+
+// loggingConfigChange applies the new config.
+// If the new config is invalid, the old config is kept.
+func loggingConfigChange(oldConfig, newConfig karotteapi.Config) error {
+	old := loggingCurrent.Load()
+
+	state, err := newLoggingState(newConfig, old)
+	if err != nil {
+		return err
+	}
+
+	loggingCurrent.Store(state)
+
+	// the old output is closed when the requests still using it are logged
+	if old != nil && old.out != nil && old.out != state.out {
+		old.out.retire()
+	}
+	return nil
+}
+
 // Automatically register this middleware globally
 func init() {
 	core.RegisterMiddleware(loggingMiddleware)
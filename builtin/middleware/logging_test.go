@@ -0,0 +1,104 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/karotte128/karotteapi"
+)
+
+func TestLoggingConfigChangeKeepsOutputOfRunningRequests(t *testing.T) {
+	dir := t.TempDir()
+	oldPath := filepath.Join(dir, "old.log")
+	newPath := filepath.Join(dir, "new.log")
+
+	started := make(chan struct{})
+	finish := make(chan struct{})
+	handler := loggingHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			close(started)
+			<-finish
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	err := loggingConfigChange(nil, karotteapi.Config{"format": "template", "template": "{path} {status}", "output": oldPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := loggingCurrent.Load().out
+
+	done := make(chan struct{})
+	go func() {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/slow", nil))
+		close(done)
+	}()
+	<-started
+
+	err = loggingConfigChange(nil, karotteapi.Config{"format": "template", "template": "{path} {status}", "output": newPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if old.closed {
+		t.Fatal("the old output was closed while a request used it")
+	}
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/fast", nil))
+	close(finish)
+	<-done
+
+	if !old.closed {
+		t.Error("the old output was not closed after the last request")
+	}
+
+	for path, want := range map[string]string{oldPath: "/slow 204", newPath: "/fast 204"} {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.TrimSpace(string(data)) != want {
+			t.Errorf("%s = %q, want %q", filepath.Base(path), data, want)
+		}
+	}
+
+	loggingCurrent.Load().out.retire()
+}
+
+func TestLoggingLogsRequestsRejectedByOuterMiddleware(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+
+	// the chain as the framework builds it: ratelimit (3) runs outside logging (1)
+	handler := rateLimitHandler(loggingHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})))
+	// sets the limit of the chain above
+	newRateLimitTest(t, karotteapi.Config{"limit": int64(1), "window": int64(60)})
+	t.Cleanup(func() {
+		loggingCurrent.Load().out.retire()
+		loggingCurrent.Store(nil)
+	})
+
+	err := loggingConfigChange(nil, karotteapi.Config{"format": "template", "template": "{path} {status}", "output": path})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, p := range []string{"/orders/1", "/orders/2"} {
+		r := httptest.NewRequest(http.MethodGet, p, nil)
+		r.RemoteAddr = "192.0.2.1:1234"
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every request is logged exactly once
+	if want := "/orders/1 204\n/orders/2 429\n"; string(data) != want {
+		t.Errorf("access log = %q, want %q", data, want)
+	}
+}
@@ -0,0 +1,82 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/karotte128/karotteapi"
+	"github.com/karotte128/karotteapi/core"
+	"github.com/karotte128/karotteapi/metrics"
+)
+
+// The metrics middleware records request counts, durations and running requests
+// in the metrics registry (see core.Metrics). The metrics module exposes them.
+// The labels are the module serving the request, the method and the status class (e.g. "2xx").
+// Requests that are not served by a module have the module label "none".
+//
+// [middleware.metrics]
+// enable = true
+// buckets = [0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10]   # duration buckets in seconds
+//
+// The buckets are only read on startup.
+
+var metricsMiddleware = karotteapi.Middleware{
+	Name:        "metrics",
+	Handler:     metricsHandler,
+	Priority:    50,
+	ForceEnable: false,
+}
+
+// metricsMethods are the methods used as label values. Other methods are counted as "OTHER",
+// so clients can not create an unlimited number of series.
+var metricsMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodPost:    true,
+	http.MethodPut:     true,
+	http.MethodPatch:   true,
+	http.MethodDelete:  true,
+	http.MethodOptions: true,
+}
+
+// statusClass returns the class of the status code (e.g. "2xx").
+func statusClass(status int) string {
+	return strconv.Itoa(status/100) + "xx"
+}
+
+func metricsHandler(next http.Handler) http.Handler {
+	conf, _ := core.GetMiddlewareConfig("metrics")
+	buckets := configFloats(conf, metrics.DefaultBuckets, "buckets")
+
+	registry := core.Metrics()
+	requests := registry.Counter("karotteapi_http_requests_total", "Number of handled HTTP requests.", "module", "method", "status")
+	durations := registry.Histogram("karotteapi_http_request_duration_seconds", "Duration of HTTP requests in seconds.", buckets, "module", "method", "status")
+	inFlight := registry.Gauge("karotteapi_http_requests_in_flight", "Number of HTTP requests that are currently handled.", "module")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		module, ok := core.GetModuleForRequest(r)
+		if !ok {
+			module = "none"
+		}
+
+		method := r.Method
+		if !metricsMethods[method] {
+			method = "OTHER"
+		}
+
+		inFlight.Inc(module)
+		defer inFlight.Dec(module)
+
+		rw := core.NewResponseWriter(w)
+
+		next.ServeHTTP(rw, r)
+
+		status := statusClass(rw.Status())
+		requests.Inc(module, method, status)
+		durations.Observe(rw.Duration().Seconds(), module, method, status)
+	})
+}
+
+func init() {
+	core.RegisterMiddleware(metricsMiddleware)
+}
@@ -0,0 +1,260 @@
+package middleware
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/karotte128/karotteapi"
+	"github.com/karotte128/karotteapi/core"
+)
+
+// The ratelimit middleware limits the requests per client.
+// The state is kept in the rate limit store (see core.SetRateLimitStore), in memory by default.
+//
+// [middleware.ratelimit]
+// enable = true
+// algorithm = "token_bucket"     # or "sliding_window"
+// limit = 100                    # requests per window, 0 disables the limit
+// window = 60                    # seconds
+// burst = 100                    # token bucket capacity, defaults to limit
+// key = "ip"                     # "ip", "principal" or "header:<name>"
+// trusted_proxies = ["10.0.0.0/8"]  # proxies whose X-Forwarded-For header is used for the client ip
+//
+// [middleware.ratelimit.modules.health]       # per-module override
+// limit = 0
+//
+// [middleware.ratelimit.routes."POST /orders/"]  # per-route override, matched by path prefix
+// limit = 10
+//
+// Overrides only need to contain the values that differ.
+
+var rateLimitMiddleware = karotteapi.Middleware{
+	Name:           "ratelimit",
+	Handler:        rateLimitHandler,
+	Priority:       3,
+	ForceEnable:    false,
+	OnConfigChange: rateLimitConfigChange,
+}
+
+// rateLimitRule is a rate limit and how the clients are identified.
+type rateLimitRule struct {
+	// scope separates the state of different rules in the store.
+	scope string
+	limit karotteapi.RateLimit
+	key   string
+}
+
+// rateLimitRoute is a per-route rule.
+type rateLimitRoute struct {
+	method string
+	path   string
+	rule   rateLimitRule
+}
+
+// rateLimitState is the config of the ratelimit middleware.
+type rateLimitState struct {
+	global  rateLimitRule
+	modules map[string]rateLimitRule
+	routes  []rateLimitRoute
+	proxies trustedProxies
+}
+
+// rateLimitCurrent is the current config of the ratelimit middleware. It is replaced on config change.
+var rateLimitCurrent atomic.Pointer[rateLimitState]
+
+// readRateLimitRule reads a rule from the config. Missing values are taken from base.
+func readRateLimitRule(conf karotteapi.Config, scope string, base rateLimitRule) (rateLimitRule, error) {
+	rule := rateLimitRule{
+		scope: scope,
+		limit: karotteapi.RateLimit{
+			Algorithm: core.GetNestedValueOrDefault(conf, base.limit.Algorithm, "algorithm"),
+			Limit:     int(configInt(conf, int64(base.limit.Limit), "limit")),
+			Window:    configSeconds(conf, base.limit.Window, "window"),
+			Burst:     int(configInt(conf, int64(base.limit.Burst), "burst")),
+		},
+		key: core.GetNestedValueOrDefault(conf, base.key, "key"),
+	}
+
+	if rule.limit.Algorithm != "token_bucket" && rule.limit.Algorithm != "sliding_window" {
+		return rule, fmt.Errorf("%s: unknown algorithm %q", scope, rule.limit.Algorithm)
+	}
+	if rule.limit.Limit > 0 && rule.limit.Window <= 0 {
+		return rule, fmt.Errorf("%s: window must be positive", scope)
+	}
+	if rule.key != "ip" && rule.key != "principal" && !strings.HasPrefix(rule.key, "header:") {
+		return rule, fmt.Errorf("%s: unknown key %q", scope, rule.key)
+	}
+
+	return rule, nil
+}
+
+// newRateLimitState creates the ratelimit config from the middleware config block.
+func newRateLimitState(conf karotteapi.Config) (*rateLimitState, error) {
+	defaults := rateLimitRule{
+		limit: karotteapi.RateLimit{Algorithm: "token_bucket", Limit: 100, Window: time.Minute},
+		key:   "ip",
+	}
+
+	global, err := readRateLimitRule(conf, "global", defaults)
+	if err != nil {
+		return nil, err
+	}
+
+	proxies, err := readTrustedProxies(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	state := &rateLimitState{
+		global:  global,
+		modules: map[string]rateLimitRule{},
+		proxies: proxies,
+	}
+
+	modules, _ := core.GetNestedValue[map[string]any](conf, "modules")
+	for module, moduleConf := range modules {
+		blockConf, _ := moduleConf.(map[string]any)
+		rule, err := readRateLimitRule(blockConf, "module:"+module, global)
+		if err != nil {
+			return nil, err
+		}
+		state.modules[module] = rule
+	}
+
+	routes, _ := core.GetNestedValue[map[string]any](conf, "routes")
+	for route, routeConf := range routes {
+		blockConf, _ := routeConf.(map[string]any)
+		rule, err := readRateLimitRule(blockConf, "route:"+route, global)
+		if err != nil {
+			return nil, err
+		}
+
+		method, path, found := strings.Cut(route, " ")
+		if !found {
+			method, path = "", route
+		}
+		state.routes = append(state.routes, rateLimitRoute{method: method, path: path, rule: rule})
+	}
+
+	return state, nil
+}
+
+// findRule returns the rule for the request: the longest matching route, the module or the global rule.
+func (state *rateLimitState) findRule(r *http.Request) rateLimitRule {
+	var match *rateLimitRoute
+	for i, route := range state.routes {
+		if route.method != "" && route.method != r.Method {
+			continue
+		}
+		if !strings.HasPrefix(r.URL.Path, route.path) {
+			continue
+		}
+		if match == nil || len(route.path) > len(match.path) {
+			match = &state.routes[i]
+		}
+	}
+	if match != nil {
+		return match.rule
+	}
+
+	module, ok := core.GetModuleForRequest(r)
+	if ok {
+		rule, ok := state.modules[module]
+		if ok {
+			return rule
+		}
+	}
+
+	return state.global
+}
+
+// clientKey identifies the client of the request for the rule.
+// If the principal or header is missing, the client ip is used.
+func (state *rateLimitState) clientKey(r *http.Request, rule rateLimitRule) string {
+	switch {
+	case rule.key == "principal":
+		principal, ok := core.GetPrincipal(r.Context())
+		if ok {
+			return "principal:" + principal.ID
+		}
+
+	case strings.HasPrefix(rule.key, "header:"):
+		name := strings.TrimPrefix(rule.key, "header:")
+		value := r.Header.Get(name)
+		if value != "" {
+			return "header:" + name + ":" + value
+		}
+	}
+
+	return "ip:" + clientIP(r, state.proxies)
+}
+
+// ceilSeconds returns the duration in whole seconds, rounded up.
+func ceilSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+func rateLimitHandler(next http.Handler) http.Handler {
+	conf, _ := core.GetMiddlewareConfig("ratelimit")
+	state, err := newRateLimitState(conf)
+	if err != nil {
+		// without a valid config, the default limit is used
+		core.MiddlewareLogger("ratelimit").Error("invalid config, using defaults", "error", err)
+		state, _ = newRateLimitState(nil)
+	}
+	rateLimitCurrent.Store(state)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		state := rateLimitCurrent.Load()
+
+		rule := state.findRule(r)
+		if rule.limit.Limit <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key := rule.scope + "|" + state.clientKey(r, rule)
+		result, err := core.GetRateLimitStore().Take(r.Context(), key, rule.limit)
+		if err != nil {
+			// the store is not available, do not block the API
+			core.MiddlewareLogger("ratelimit").ErrorContext(r.Context(), "store error", "error", err)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		header := w.Header()
+		header.Set("RateLimit-Limit", strconv.Itoa(rule.limit.Limit))
+		header.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		header.Set("RateLimit-Reset", ceilSeconds(result.Reset))
+		header.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%s", rule.limit.Limit, ceilSeconds(rule.limit.Window)))
+
+		if !result.Allowed {
+			header.Set("Retry-After", ceilSeconds(result.RetryAfter))
+			rejectRequest(w, r, core.NewHTTPError(http.StatusTooManyRequests, "rate_limited", "rate limit exceeded"))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rateLimitConfigChange applies the new config.
+// If the new config is invalid, the old config is kept.
+func rateLimitConfigChange(oldConfig, newConfig karotteapi.Config) error {
+	state, err := newRateLimitState(newConfig)
+	if err != nil {
+		return err
+	}
+
+	rateLimitCurrent.Store(state)
+	return nil
+}
+
+func init() {
+	core.RegisterMiddleware(rateLimitMiddleware)
+}
@@ -0,0 +1,131 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/karotte128/karotteapi"
+	"github.com/karotte128/karotteapi/core"
+)
+
+// fakeRateLimitStore allows limit.Limit requests per key and records the keys.
+type fakeRateLimitStore struct {
+	counts map[string]int
+	keys   []string
+	err    error
+}
+
+func (s *fakeRateLimitStore) Take(ctx context.Context, key string, limit karotteapi.RateLimit) (karotteapi.RateLimitResult, error) {
+	s.keys = append(s.keys, key)
+	if s.err != nil {
+		return karotteapi.RateLimitResult{}, s.err
+	}
+
+	s.counts[key]++
+	if s.counts[key] > limit.Limit {
+		return karotteapi.RateLimitResult{Reset: limit.Window, RetryAfter: 1500 * time.Millisecond}, nil
+	}
+	return karotteapi.RateLimitResult{Allowed: true, Remaining: limit.Limit - s.counts[key], Reset: limit.Window}, nil
+}
+
+// newRateLimitTest sets a fake store and returns it with the handler using the config.
+func newRateLimitTest(t *testing.T, conf karotteapi.Config) (*fakeRateLimitStore, http.Handler) {
+	t.Helper()
+
+	store := &fakeRateLimitStore{counts: map[string]int{}}
+	old := core.GetRateLimitStore()
+	core.SetRateLimitStore(store)
+	t.Cleanup(func() { core.SetRateLimitStore(old) })
+
+	state, err := newRateLimitState(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := rateLimitHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	rateLimitCurrent.Store(state)
+
+	return store, handler
+}
+
+func rateLimitRequest(handler http.Handler, remoteAddr string, header http.Header) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "/orders/1", nil)
+	r.RemoteAddr = remoteAddr
+	for name, values := range header {
+		r.Header[name] = values
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+func TestRateLimitRejects(t *testing.T) {
+	_, handler := newRateLimitTest(t, karotteapi.Config{"limit": int64(2), "window": int64(60)})
+
+	for i := range 2 {
+		w := rateLimitRequest(handler, "192.0.2.1:1234", nil)
+		if w.Code != http.StatusNoContent {
+			t.Fatalf("request %d: status %d", i, w.Code)
+		}
+	}
+
+	w := rateLimitRequest(handler, "192.0.2.1:1234", nil)
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("status = %d", w.Code)
+	}
+	for name, want := range map[string]string{
+		"RateLimit-Limit":     "2",
+		"RateLimit-Remaining": "0",
+		"RateLimit-Reset":     "60",
+		"RateLimit-Policy":    "2;w=60",
+		"Retry-After":         "2",
+	} {
+		if got := w.Header().Get(name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+
+	// other clients have their own limit
+	if w := rateLimitRequest(handler, "192.0.2.2:1234", nil); w.Code != http.StatusNoContent {
+		t.Errorf("other client: status %d", w.Code)
+	}
+}
+
+func TestRateLimitKeys(t *testing.T) {
+	store, handler := newRateLimitTest(t, karotteapi.Config{
+		"key":             "header:X-API-Key",
+		"trusted_proxies": []any{"10.0.0.0/8"},
+	})
+
+	rateLimitRequest(handler, "192.0.2.1:1234", http.Header{"X-Api-Key": {"abc"}})
+	// without the header, the client ip is used
+	rateLimitRequest(handler, "192.0.2.1:1234", nil)
+	// behind a trusted proxy, the forged first entry is not used
+	rateLimitRequest(handler, "10.0.0.1:1234", http.Header{"X-Forwarded-For": {"203.0.113.9, 198.51.100.7, 10.0.0.2"}})
+
+	want := []string{"global|header:X-API-Key:abc", "global|ip:192.0.2.1", "global|ip:198.51.100.7"}
+	if len(store.keys) != len(want) {
+		t.Fatalf("keys = %v", store.keys)
+	}
+	for i := range want {
+		if store.keys[i] != want[i] {
+			t.Errorf("key %d = %q, want %q", i, store.keys[i], want[i])
+		}
+	}
+}
+
+func TestRateLimitStoreErrorDoesNotBlock(t *testing.T) {
+	store, handler := newRateLimitTest(t, nil)
+	store.err = errors.New("store is down")
+
+	if w := rateLimitRequest(handler, "192.0.2.1:1234", nil); w.Code != http.StatusNoContent {
+		t.Errorf("status = %d", w.Code)
+	}
+}
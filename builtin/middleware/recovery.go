@@ -1,16 +1,22 @@
 package middleware
 
 import (
-	"log"
 	"net/http"
+	"runtime/debug"
 
 	"github.com/karotte128/karotteapi"
 	"github.com/karotte128/karotteapi/core"
+	"github.com/karotte128/karotteapi/internal"
 )
 
 // RecoveryMiddleware wraps every request handler in a recover() block.
 // If a module panics, the panic is caught here so the server remains alive.
-// The user receives a 500 error and the panic is logged.
+// The panic is logged with its stack trace and an error id, the hooks registered
+// with core.OnPanic are called and the user receives a 500 problem details response containing the error id.
+// If the handler already started the response, the connection is aborted instead.
+//
+// [middleware.recovery]
+// log_stack = true   # log the stack trace of the panic
 
 var recoveryMiddleware = karotteapi.Middleware{
 	Name:        "recovery",
@@ -20,15 +26,51 @@ var recoveryMiddleware = karotteapi.Middleware{
 }
 
 func recoveryHandler(next http.Handler) http.Handler {
+	conf, _ := core.GetMiddlewareConfig("recovery")
+	logStack := core.GetNestedValueOrDefault(conf, true, "log_stack")
+	logger := core.MiddlewareLogger("recovery")
+
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rw := core.NewResponseWriter(w)
+
 		defer func() {
-			if err := recover(); err != nil {
-				log.Printf("[RECOVERY] Panic caught: %v", err)
-				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			err := recover()
+			if err == nil {
+				return
+			}
+
+			// http.ErrAbortHandler aborts the response on purpose, the server handles it
+			if err == http.ErrAbortHandler {
+				panic(err)
+			}
+
+			info := karotteapi.PanicInfo{
+				Value:   err,
+				Stack:   debug.Stack(),
+				ErrorID: internal.NewULID(),
+				Request: r,
 			}
+
+			args := []any{"method", r.Method, "path", r.URL.Path, "panic", err, "error_id", info.ErrorID}
+			if logStack {
+				args = append(args, "stack", string(info.Stack))
+			}
+			logger.ErrorContext(r.Context(), "panic caught", args...)
+
+			internal.ReportPanic(info)
+
+			if rw.Written() {
+				// the status was already sent, abort the connection so the client
+				// does not take the incomplete response as complete
+				panic(http.ErrAbortHandler)
+			}
+
+			problem := core.NewHTTPError(http.StatusInternalServerError, "internal_error", "an unexpected error occurred")
+			problem.Extensions = map[string]any{"error_id": info.ErrorID}
+			core.WriteError(w, r, problem)
 		}()
 
-		next.ServeHTTP(w, r)
+		next.ServeHTTP(rw, r)
 	})
 }
 
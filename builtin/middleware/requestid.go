@@ -0,0 +1,103 @@
+package middleware
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"github.com/karotte128/karotteapi"
+	"github.com/karotte128/karotteapi/core"
+	"github.com/karotte128/karotteapi/internal"
+)
+
+// The requestid middleware accepts or generates a request id, stores it in the
+// request context (see core.GetRequestID) and echoes it in the response.
+// It wraps the logging and recovery middleware, so they can log the id.
+//
+// [middleware.requestid]
+// enable = true
+// header = "X-Request-ID"
+// format = "uuidv7"          # or "ulid"
+// trust_incoming = true      # accept ids sent by the client
+
+var requestIDMiddleware = karotteapi.Middleware{
+	Name:           "requestid",
+	Handler:        requestIDHandler,
+	Priority:       30,
+	ForceEnable:    false,
+	OnConfigChange: requestIDConfigChange,
+}
+
+// maxRequestIDLength is the maximum length of an incoming request id.
+const maxRequestIDLength = 128
+
+// requestIDState is the config of the requestid middleware.
+type requestIDState struct {
+	header        string
+	generate      func() string
+	trustIncoming bool
+}
+
+// requestIDCurrent is the current config of the requestid middleware. It is replaced on config change.
+var requestIDCurrent atomic.Pointer[requestIDState]
+
+// newRequestIDState creates the requestid config from the middleware config block.
+func newRequestIDState(conf karotteapi.Config) *requestIDState {
+	state := &requestIDState{
+		header:        core.GetNestedValueOrDefault(conf, "X-Request-ID", "header"),
+		generate:      internal.NewUUIDv7,
+		trustIncoming: core.GetNestedValueOrDefault(conf, true, "trust_incoming"),
+	}
+
+	switch format := core.GetNestedValueOrDefault(conf, "uuidv7", "format"); format {
+	case "uuidv7":
+	case "ulid":
+		state.generate = internal.NewULID
+	default:
+		core.MiddlewareLogger("requestid").Warn("unknown format, using uuidv7", "format", format)
+	}
+
+	return state
+}
+
+// validRequestID reports whether an incoming request id can be used.
+// Only printable ASCII characters are allowed, so the id can not break log lines or headers.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+func requestIDHandler(next http.Handler) http.Handler {
+	conf, _ := core.GetMiddlewareConfig("requestid")
+	requestIDCurrent.Store(newRequestIDState(conf))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		state := requestIDCurrent.Load()
+
+		id := r.Header.Get(state.header)
+		if !state.trustIncoming || !validRequestID(id) {
+			id = state.generate()
+		}
+
+		w.Header().Set(state.header, id)
+
+		next.ServeHTTP(w, r.WithContext(core.SetRequestID(r.Context(), id)))
+	})
+}
+
+// requestIDConfigChange applies the new config.
+func requestIDConfigChange(oldConfig, newConfig karotteapi.Config) error {
+	requestIDCurrent.Store(newRequestIDState(newConfig))
+	return nil
+}
+
+func init() {
+	core.RegisterMiddleware(requestIDMiddleware)
+}
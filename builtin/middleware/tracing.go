@@ -0,0 +1,79 @@
+package middleware
+
+import (
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/karotte128/karotteapi"
+	"github.com/karotte128/karotteapi/core"
+	"github.com/karotte128/karotteapi/internal"
+)
+
+// The tracing middleware starts an OpenTelemetry server span for each request. The trace
+// context of the caller is read with the global propagator (W3C traceparent and baggage). The span is stored in the
+// request context, so handlers can start child spans with core.StartSpan.
+// The tracer and the exporter are configured in [server.tracing].
+//
+// [middleware.tracing]
+// enable = true
+
+var tracingMiddleware = karotteapi.Middleware{
+	Name:        "tracing",
+	Handler:     tracingHandler,
+	Priority:    40,
+	ForceEnable: false,
+}
+
+func tracingHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+
+		name := r.Method
+		module, mount, ok := internal.GetModuleMountForPath(r.URL.Path)
+		if ok {
+			name += " " + mount
+		}
+
+		ctx, span := core.Tracer().Start(ctx, name, trace.WithSpanKind(trace.SpanKindServer))
+		defer span.End()
+
+		if span.IsRecording() {
+			span.SetAttributes(
+				attribute.String("http.request.method", r.Method),
+				attribute.String("url.path", r.URL.Path),
+				attribute.String("url.scheme", requestScheme(r)),
+				attribute.String("server.address", r.Host),
+				attribute.String("user_agent.original", r.UserAgent()),
+			)
+			if ok {
+				span.SetAttributes(attribute.String("http.route", mount), attribute.String("karotteapi.module", module))
+			}
+		}
+
+		rw := core.NewResponseWriter(w)
+
+		next.ServeHTTP(rw, r.WithContext(ctx))
+
+		span.SetAttributes(attribute.Int("http.response.status_code", rw.Status()))
+		if rw.Status() >= 500 {
+			span.SetStatus(codes.Error, http.StatusText(rw.Status()))
+		}
+	})
+}
+
+// requestScheme returns the scheme of the request.
+func requestScheme(r *http.Request) string {
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+func init() {
+	core.RegisterMiddleware(tracingMiddleware)
+}
@@ -0,0 +1,76 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/karotte128/karotteapi/core"
+)
+
+// newTracingTest sets a global tracer provider that records the ended spans.
+func newTracingTest(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+
+	recorder := tracetest.NewSpanRecorder()
+	old := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(old) })
+
+	return recorder
+}
+
+func TestTracingContinuesTrace(t *testing.T) {
+	recorder := newTracingTest(t)
+
+	var child trace.SpanContext
+	handler := tracingHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, span := core.StartSpan(r.Context(), "load order")
+		child = span.SpanContext()
+		span.End()
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "/orders/1", nil)
+	r.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("%d spans ended", len(spans))
+	}
+	server := spans[1]
+
+	if got := server.SpanContext().TraceID().String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("trace id = %s", got)
+	}
+	if got := server.Parent().SpanID().String(); got != "00f067aa0ba902b7" {
+		t.Errorf("parent span id = %s", got)
+	}
+	if server.SpanKind() != trace.SpanKindServer {
+		t.Errorf("kind = %v", server.SpanKind())
+	}
+	if server.Status().Code != codes.Error {
+		t.Errorf("status = %v", server.Status())
+	}
+	if child.TraceID() != server.SpanContext().TraceID() || spans[0].Parent().SpanID() != server.SpanContext().SpanID() {
+		t.Error("child span is not a child of the server span")
+	}
+
+	found := false
+	for _, attr := range server.Attributes() {
+		if attr == attribute.Int("http.response.status_code", 500) {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("attributes = %v", server.Attributes())
+	}
+}
@@ -0,0 +1,11 @@
+package config
+
+import (
+	"net/http"
+
+	"github.com/karotte128/karotteapi/core"
+)
+
+func configReport(w http.ResponseWriter, r *http.Request) {
+	core.WriteResponse(w, r, http.StatusOK, core.GetConfigReport())
+}
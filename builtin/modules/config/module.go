@@ -0,0 +1,49 @@
+package config
+
+import (
+	"net/http"
+
+	"github.com/karotte128/karotteapi"
+	"github.com/karotte128/karotteapi/core"
+)
+
+// The config module exposes the effective config for debugging.
+// Sensitive values are redacted. It should only be enabled on admin deployments.
+//
+// [modules.config]
+// enable = true
+// path = "/admin/config"
+
+// defaultPath is the path of the endpoint if none is configured.
+const defaultPath = "/admin/config"
+
+var configModule = karotteapi.Module{
+	Name:       "config",
+	Routes:     routes,
+	Operations: operations,
+}
+
+func routes() (string, http.Handler) {
+	conf, _ := core.GetModuleConfig("config")
+	path := core.GetNestedValueOrDefault(conf, defaultPath, "path")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(path, configReport)
+	return path, mux
+}
+
+func operations() []karotteapi.Operation {
+	conf, _ := core.GetModuleConfig("config")
+	path := core.GetNestedValueOrDefault(conf, defaultPath, "path")
+
+	return []karotteapi.Operation{{
+		Method:   http.MethodGet,
+		Path:     path,
+		Summary:  "Get the effective config with redacted secrets",
+		Response: karotteapi.ConfigReport{},
+	}}
+}
+
+func init() {
+	core.RegisterModule(configModule)
+}
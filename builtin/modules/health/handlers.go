@@ -1,23 +1,23 @@
 package health
 
 import (
-	"encoding/json"
 	"net/http"
 
+	"github.com/karotte128/karotteapi/core"
 	"github.com/karotte128/karotteapi/internal"
 )
 
-func health(w http.ResponseWriter, r *http.Request) {
-
-	type response struct {
-		ApiStatus         string `json:"apiStatus"`
-		TotalModules      int    `json:"totalModules"`
-		RegisteredModules int    `json:"registeredModules"`
-		RunningModules    int    `json:"runningModules"`
-		DisabledModules   int    `json:"disabledModules"`
-		FailedModules     int    `json:"failedModules"`
-	}
+// healthResponse is the response of the health endpoint.
+type healthResponse struct {
+	ApiStatus         string `json:"apiStatus"`
+	TotalModules      int    `json:"totalModules"`
+	RegisteredModules int    `json:"registeredModules"`
+	RunningModules    int    `json:"runningModules"`
+	DisabledModules   int    `json:"disabledModules"`
+	FailedModules     int    `json:"failedModules"`
+}
 
+func health(w http.ResponseWriter, r *http.Request) {
 	status := internal.GetModuleStatus()
 
 	var apiStatus string
@@ -28,7 +28,7 @@ func health(w http.ResponseWriter, r *http.Request) {
 		apiStatus = "degraded"
 	}
 
-	req_response := response{
+	req_response := healthResponse{
 		ApiStatus:         apiStatus,
 		TotalModules:      status.TotalModules,
 		RegisteredModules: status.RegisteredModules,
@@ -37,5 +37,5 @@ func health(w http.ResponseWriter, r *http.Request) {
 		FailedModules:     status.FailedModules,
 	}
 
-	json.NewEncoder(w).Encode(req_response)
+	core.WriteResponse(w, r, http.StatusOK, req_response)
 }
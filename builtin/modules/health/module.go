@@ -1,7 +1,6 @@
 package health
 
 import (
-	"log"
 	"net/http"
 
 	"github.com/karotte128/karotteapi"
@@ -9,10 +8,11 @@ import (
 )
 
 var healthModule = karotteapi.Module{
-	Name:     "health",
-	Routes:   routes,
-	Startup:  startup,
-	Shutdown: shutdown,
+	Name:       "health",
+	Routes:     routes,
+	Startup:    startup,
+	Shutdown:   shutdown,
+	Operations: operations,
 }
 
 func routes() (string, http.Handler) {
@@ -21,13 +21,22 @@ func routes() (string, http.Handler) {
 	return "/health", mux
 }
 
+func operations() []karotteapi.Operation {
+	return []karotteapi.Operation{{
+		Method:   http.MethodGet,
+		Path:     "/health",
+		Summary:  "Get the status of the API and its modules",
+		Response: healthResponse{},
+	}}
+}
+
 func startup() error {
-	log.Println("[MODULE] Starting the health module!")
+	core.Logger("health").Info("starting the health module")
 	return nil
 }
 
 func shutdown() error {
-	log.Println("[MODULE] Shutting down the health module!")
+	core.Logger("health").Info("shutting down the health module")
 	return nil
 }
 
@@ -0,0 +1,11 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/karotte128/karotteapi/core"
+)
+
+func exposeMetrics(w http.ResponseWriter, r *http.Request) {
+	core.Metrics().ServeHTTP(w, r)
+}
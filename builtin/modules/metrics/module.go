@@ -0,0 +1,52 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/karotte128/karotteapi"
+	"github.com/karotte128/karotteapi/core"
+	"github.com/karotte128/karotteapi/metrics"
+)
+
+// The metrics module exposes the metrics registry (see core.Metrics) in the
+// Prometheus text format, or in the OpenMetrics format if the client accepts it.
+// Request metrics are recorded by the metrics middleware.
+//
+// [modules.metrics]
+// enable = true
+// path = "/metrics"
+
+// defaultPath is the path of the endpoint if none is configured.
+const defaultPath = "/metrics"
+
+var metricsModule = karotteapi.Module{
+	Name:       "metrics",
+	Routes:     routes,
+	Operations: operations,
+}
+
+func routes() (string, http.Handler) {
+	conf, _ := core.GetModuleConfig("metrics")
+	path := core.GetNestedValueOrDefault(conf, defaultPath, "path")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(path, exposeMetrics)
+	return path, mux
+}
+
+func operations() []karotteapi.Operation {
+	conf, _ := core.GetModuleConfig("metrics")
+	path := core.GetNestedValueOrDefault(conf, defaultPath, "path")
+
+	return []karotteapi.Operation{{
+		Method:       http.MethodGet,
+		Path:         path,
+		Summary:      "Get the metrics in the Prometheus text format",
+		Response:     "",
+		ResponseType: metrics.ContentTypeText,
+	}}
+}
+
+func init() {
+	core.RegisterModule(metricsModule)
+}
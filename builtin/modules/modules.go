@@ -22,5 +22,8 @@ package modules
 // The core system will pick it up automatically.
 
 import (
+	_ "github.com/karotte128/karotteapi/builtin/modules/config"
 	_ "github.com/karotte128/karotteapi/builtin/modules/health"
+	_ "github.com/karotte128/karotteapi/builtin/modules/metrics"
+	_ "github.com/karotte128/karotteapi/builtin/modules/openapi"
 )
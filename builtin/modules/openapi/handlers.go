@@ -0,0 +1,29 @@
+package openapi
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/karotte128/karotteapi/core"
+)
+
+func serveDocument(w http.ResponseWriter, r *http.Request) {
+	document, err := core.OpenAPI()
+	if err != nil {
+		core.WriteError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(document)))
+	w.Write(document)
+}
+
+func serveDocs(w http.ResponseWriter, r *http.Request) {
+	header := w.Header()
+	header.Set("Content-Type", "text/html; charset=utf-8")
+	// the page only needs its inline script and style and the document of the same origin
+	header.Set("Content-Security-Policy", "default-src 'none'; script-src 'unsafe-inline'; style-src 'unsafe-inline'; connect-src 'self'")
+	header.Set("Content-Length", strconv.Itoa(len(docsPage)))
+	w.Write(docsPage)
+}
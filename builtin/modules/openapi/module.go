@@ -0,0 +1,47 @@
+package openapi
+
+import (
+	_ "embed"
+	"net/http"
+	"strings"
+
+	"github.com/karotte128/karotteapi"
+	"github.com/karotte128/karotteapi/core"
+)
+
+// The openapi module serves the OpenAPI 3.1 document of all running modules (see core.OpenAPI)
+// at <path>/openapi.json and a documentation UI at <path>/.
+// The UI is embedded and does not load anything from external servers.
+//
+// [modules.openapi]
+// enable = true
+// path = "/admin/openapi"
+// docs = true   # serve the documentation UI
+
+// defaultPath is the path of the endpoints if none is configured.
+const defaultPath = "/admin/openapi"
+
+//go:embed docs.html
+var docsPage []byte
+
+var openapiModule = karotteapi.Module{
+	Name:   "openapi",
+	Routes: routes,
+}
+
+func routes() (string, http.Handler) {
+	conf, _ := core.GetModuleConfig("openapi")
+	path := strings.TrimSuffix(core.GetNestedValueOrDefault(conf, defaultPath, "path"), "/")
+	docs := core.GetNestedValueOrDefault(conf, true, "docs")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+path+"/openapi.json", serveDocument)
+	if docs {
+		mux.HandleFunc("GET "+path+"/{$}", serveDocs)
+	}
+	return path + "/", mux
+}
+
+func init() {
+	core.RegisterModule(openapiModule)
+}
@@ -0,0 +1,231 @@
+package config
+
+// This package loads the API config from files, environment variables and
+// command-line flags and merges them into one karotteapi.Config.
+//
+// The sources are merged in this order, later sources overwrite earlier ones:
+//
+//   1. the default file (e.g. "config.toml")
+//   2. the environment overlay file (e.g. "config.production.toml")
+//   3. environment variables with the prefix (e.g. KAROTTE_MODULES__HEALTH__ENABLE=false)
+//   4. command-line flags (e.g. -set modules.health.enable=false)
+//
+// ${VAR} and ${VAR:-default} in file values are replaced with the environment variable.
+// Values of environment variables and flags get the type of the value a file set at the same
+// path. Without a file value, true, false, numbers and JSON arrays are converted (see parseValue).
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	cfg "github.com/karotte128/karottelib/config"
+
+	"github.com/karotte128/karotteapi"
+)
+
+// DefaultEnvPrefix is the prefix of environment variables that are merged into the config.
+const DefaultEnvPrefix = "KAROTTE_"
+
+// Options describes where the config is loaded from.
+type Options struct {
+	// DefaultFile is the path of the base config file.
+	// The format is selected by the file extension (.toml, .yaml, .yml or .json).
+	DefaultFile string
+
+	// Environment is the name of the environment (e.g. "production").
+	// If set, the overlay file "<name>.<environment>.<ext>" next to DefaultFile is merged.
+	Environment string
+
+	// EnvPrefix is the prefix of environment variables that are merged into the config.
+	// If empty, DefaultEnvPrefix is used.
+	EnvPrefix string
+
+	// DisableEnv disables merging environment variables.
+	DisableEnv bool
+
+	// Args are the command-line arguments (usually os.Args[1:]).
+	// Supported flags:
+	//   -config <file>          overrides DefaultFile
+	//   -env <environment>      overrides Environment
+	//   -set <path>=<value>     sets a config value, can be repeated
+	// The flags can also be written with two dashes and as -flag=value.
+	// All other arguments belong to the host application and are ignored.
+	Args []string
+}
+
+// Sources records where each config value came from.
+// The key is the dotted config path (e.g. "modules.health.enable"),
+// the value is the source (e.g. "file:config.toml" or "env:KAROTTE_MODULES__HEALTH__ENABLE").
+type Sources map[string]string
+
+// String returns one "path = source" line per config value, sorted by path.
+func (s Sources) String() string {
+	paths := make([]string, 0, len(s))
+	for path := range s {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	var b strings.Builder
+	for _, path := range paths {
+		fmt.Fprintf(&b, "%s = %s\n", path, s[path])
+	}
+	return b.String()
+}
+
+// Load loads and merges the config from all sources described by the options.
+func Load(options Options) (karotteapi.Config, Sources, error) {
+	sets, err := parseArgs(&options)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	conf := karotteapi.Config{}
+	sources := Sources{}
+
+	// default file
+	if options.DefaultFile != "" {
+		fileConf, err := ReadFile(options.DefaultFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		merge(conf, fileConf, "", "file:"+options.DefaultFile, sources)
+	}
+
+	// environment overlay file
+	if options.Environment != "" {
+		if options.DefaultFile == "" {
+			return nil, nil, errors.New("environment overlay requires a default file")
+		}
+
+		overlay := overlayPath(options.DefaultFile, options.Environment)
+		fileConf, err := ReadFile(overlay)
+		if err != nil {
+			return nil, nil, err
+		}
+		merge(conf, fileConf, "", "file:"+overlay, sources)
+	}
+
+	// environment variables
+	if !options.DisableEnv {
+		prefix := options.EnvPrefix
+		if prefix == "" {
+			prefix = DefaultEnvPrefix
+		}
+
+		for _, env := range os.Environ() {
+			key, value, _ := strings.Cut(env, "=")
+			name, ok := strings.CutPrefix(key, prefix)
+			if !ok || name == "" {
+				continue
+			}
+
+			path := strings.Split(strings.ToLower(name), "__")
+			err := setValue(conf, path, value, "env:"+key, sources)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	// command-line flags
+	for _, set := range sets {
+		path, value, _ := strings.Cut(set, "=")
+		err := setValue(conf, strings.Split(path, "."), value, "flag:-set "+path, sources)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return conf, sources, nil
+}
+
+// Source returns a function that loads the config with the given options.
+// It can be used with core.SetConfigSource to reload the config on SIGHUP.
+func Source(options Options) func() (karotteapi.Config, error) {
+	return func() (karotteapi.Config, error) {
+		conf, _, err := Load(options)
+		return conf, err
+	}
+}
+
+// ReadFile reads a single config file.
+// The format is selected by the file extension (.toml, .yaml, .yml or .json).
+// ${VAR} and ${VAR:-default} in values are replaced with the environment variable.
+func ReadFile(path string) (karotteapi.Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading config file: %w", err)
+	}
+
+	decoder, ok := decoders[strings.ToLower(filepath.Ext(path))]
+	if !ok {
+		return nil, fmt.Errorf("unsupported config file format: %s", path)
+	}
+
+	conf, err := decoder(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed parsing config file %s: %w", path, err)
+	}
+
+	cfg.ExpandEnvRecursive(map[string]any(conf))
+
+	return conf, nil
+}
+
+// overlayPath returns the path of the environment overlay file.
+// "config.toml" with environment "production" becomes "config.production.toml".
+func overlayPath(path string, environment string) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "." + environment + ext
+}
+
+// parseArgs reads the -config, -env and -set flags from options.Args and returns the values of -set.
+// Other arguments are skipped, so the host application can have flags of its own.
+// Arguments after "--" are not read.
+func parseArgs(options *Options) ([]string, error) {
+	var sets []string
+
+	args := options.Args
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			break
+		}
+
+		name, ok := strings.CutPrefix(arg, "-")
+		if !ok {
+			continue
+		}
+		name = strings.TrimPrefix(name, "-")
+
+		name, value, hasValue := strings.Cut(name, "=")
+		if name != "config" && name != "env" && name != "set" {
+			continue
+		}
+		if !hasValue {
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("flag -%s needs a value", name)
+			}
+			i++
+			value = args[i]
+		}
+
+		switch name {
+		case "config":
+			options.DefaultFile = value
+		case "env":
+			options.Environment = value
+		case "set":
+			if !strings.Contains(value, "=") {
+				return nil, fmt.Errorf("invalid value %q for flag -set, expected path=value", value)
+			}
+			sets = append(sets, value)
+		}
+	}
+	return sets, nil
+}
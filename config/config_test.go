@@ -0,0 +1,287 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/karotte128/karotteapi"
+)
+
+// writeFile writes a config file into the test directory and returns its path.
+func writeFile(t *testing.T, dir string, name string, content string) string {
+	t.Helper()
+
+	path := filepath.Join(dir, name)
+	err := os.WriteFile(path, []byte(content), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// block returns the nested block at path.
+func block(t *testing.T, conf karotteapi.Config, path ...string) map[string]any {
+	t.Helper()
+
+	current := map[string]any(conf)
+	for _, key := range path {
+		next, ok := current[key].(map[string]any)
+		if !ok {
+			t.Fatalf("config has no block %v: %v", path, conf)
+		}
+		current = next
+	}
+	return current
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	file := writeFile(t, dir, "config.toml", `
+[server]
+address = ":8080"
+log_level = "info"
+
+[modules.health]
+enable = true
+path = "/health"
+
+[middleware.ratelimit]
+requests = 100
+burst = 10
+`)
+	writeFile(t, dir, "config.production.toml", `
+[server]
+log_level = "warn"
+
+[middleware.ratelimit]
+requests = 50
+`)
+
+	t.Setenv("TEST_MIDDLEWARE__RATELIMIT__BURST", "20")
+	t.Setenv("TEST_MODULES__HEALTH__ENABLE", "false")
+
+	conf, sources, err := Load(Options{
+		DefaultFile: file,
+		Environment: "production",
+		EnvPrefix:   "TEST_",
+		Args:        []string{"-set", "middleware.ratelimit.burst=30"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path   []string
+		value  any
+		source string
+	}{
+		{[]string{"server", "address"}, ":8080", "file:" + file},
+		{[]string{"server", "log_level"}, "warn", "file:" + filepath.Join(dir, "config.production.toml")},
+		{[]string{"modules", "health", "path"}, "/health", "file:" + file},
+		{[]string{"modules", "health", "enable"}, false, "env:TEST_MODULES__HEALTH__ENABLE"},
+		{[]string{"middleware", "ratelimit", "requests"}, int64(50), "file:" + filepath.Join(dir, "config.production.toml")},
+		{[]string{"middleware", "ratelimit", "burst"}, int64(30), "flag:-set middleware.ratelimit.burst"},
+	}
+
+	for _, test := range tests {
+		last := len(test.path) - 1
+		value := block(t, conf, test.path[:last]...)[test.path[last]]
+		if !reflect.DeepEqual(value, test.value) {
+			t.Errorf("%v = %#v, want %#v", test.path, value, test.value)
+		}
+
+		dotted := test.path[0]
+		for _, key := range test.path[1:] {
+			dotted += "." + key
+		}
+		if sources[dotted] != test.source {
+			t.Errorf("source of %s = %q, want %q", dotted, sources[dotted], test.source)
+		}
+	}
+}
+
+func TestLoadOverlayReplacesSources(t *testing.T) {
+	dir := t.TempDir()
+	file := writeFile(t, dir, "config.toml", `
+[modules.example]
+enable = true
+[modules.example.nested]
+a = 1
+`)
+
+	_, sources, err := Load(Options{
+		DefaultFile: file,
+		DisableEnv:  true,
+		Args:        []string{"-set=modules.example.nested=replaced"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := sources["modules.example.nested.a"]; ok {
+		t.Errorf("source of the replaced block is still recorded: %v", sources)
+	}
+	if sources["modules.example.nested"] != "flag:-set modules.example.nested" {
+		t.Errorf("source of modules.example.nested = %q", sources["modules.example.nested"])
+	}
+}
+
+func TestEnvValuesUseFileTypes(t *testing.T) {
+	dir := t.TempDir()
+	file := writeFile(t, dir, "config.toml", `
+[modules.example]
+enable = false
+port = 8080
+ratio = 0.5
+tags = ["a"]
+name = "x"
+`)
+
+	t.Setenv("TEST_MODULES__EXAMPLE__PASSWORD", `"123456"`)
+	t.Setenv("TEST_MODULES__EXAMPLE__TOKEN", "t")
+	t.Setenv("TEST_MODULES__EXAMPLE__ENABLE", "TRUE")
+	t.Setenv("TEST_MODULES__EXAMPLE__PORT", "9090")
+	t.Setenv("TEST_MODULES__EXAMPLE__RATIO", "1")
+	t.Setenv("TEST_MODULES__EXAMPLE__TAGS", `["b", "c"]`)
+	t.Setenv("TEST_MODULES__EXAMPLE__NAME", "42")
+
+	conf, _, err := Load(Options{DefaultFile: file, EnvPrefix: "TEST_"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]any{
+		"password": "123456",
+		"token":    "t",
+		"enable":   true,
+		"port":     int64(9090),
+		"ratio":    1.0,
+		"tags":     []any{"b", "c"},
+		"name":     "42",
+	}
+	if got := block(t, conf, "modules", "example"); !reflect.DeepEqual(got, want) {
+		t.Errorf("modules.example = %#v, want %#v", got, want)
+	}
+}
+
+func TestEnvEnablesModuleWithoutFile(t *testing.T) {
+	t.Setenv("TEST_MODULES__EXAMPLE__ENABLE", "true")
+	t.Setenv("TEST_MIDDLEWARE__RATELIMIT__LIMIT", "100")
+
+	conf, _, err := Load(Options{EnvPrefix: "TEST_"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the framework reads enable as bool and limits as int64
+	if enable, ok := block(t, conf, "modules", "example")["enable"].(bool); !ok || !enable {
+		t.Errorf("modules.example.enable = %#v", block(t, conf, "modules", "example")["enable"])
+	}
+	if limit, ok := block(t, conf, "middleware", "ratelimit")["limit"].(int64); !ok || limit != 100 {
+		t.Errorf("middleware.ratelimit.limit = %#v", block(t, conf, "middleware", "ratelimit")["limit"])
+	}
+}
+
+func TestInferValue(t *testing.T) {
+	for _, test := range []struct {
+		value string
+		want  any
+	}{
+		{"true", true},
+		{"false", false},
+		{"8080", int64(8080)},
+		{"-1", int64(-1)},
+		{"0.5", 0.5},
+		{`["a", 1]`, []any{"a", 1.0}},
+		{`"123456"`, "123456"},
+		{`"true"`, "true"},
+		{"TRUE", "TRUE"},
+		{"yes", "yes"},
+		{"007", "007"},
+		{"+1", "+1"},
+		{"1e3", "1e3"},
+		{"99999999999999999999", "99999999999999999999"},
+		{"[not json", "[not json"},
+		{`"unterminated`, `"unterminated`},
+	} {
+		got, err := parseValue(test.value, nil)
+		if err != nil {
+			t.Errorf("%s: %v", test.value, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s = %#v, want %#v", test.value, got, test.want)
+		}
+	}
+}
+
+func TestEnvValueOfWrongType(t *testing.T) {
+	dir := t.TempDir()
+	file := writeFile(t, dir, "config.toml", "[modules.example]\nenable = false\n")
+
+	// the short forms of strconv.ParseBool are not accepted
+	for _, value := range []string{"t", "F", "1", "yes"} {
+		t.Setenv("TEST_MODULES__EXAMPLE__ENABLE", value)
+
+		_, _, err := Load(Options{DefaultFile: file, EnvPrefix: "TEST_"})
+		if err == nil {
+			t.Errorf("enable=%q: no error", value)
+		}
+	}
+}
+
+func TestEnvMatchesExistingKeyCase(t *testing.T) {
+	dir := t.TempDir()
+	file := writeFile(t, dir, "config.toml", "[middleware.contentType]\nenable = true\n")
+
+	t.Setenv("TEST_MIDDLEWARE__CONTENTTYPE__DEFAULT", "text/plain")
+
+	conf, sources, err := Load(Options{DefaultFile: file, EnvPrefix: "TEST_"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := block(t, conf, "middleware", "contentType")["default"]; got != "text/plain" {
+		t.Errorf("middleware.contentType.default = %#v", got)
+	}
+	if _, ok := block(t, conf, "middleware")["contenttype"]; ok {
+		t.Error("a lower case block was created")
+	}
+	if sources["middleware.contentType.default"] != "env:TEST_MIDDLEWARE__CONTENTTYPE__DEFAULT" {
+		t.Errorf("sources = %v", sources)
+	}
+}
+
+func TestArgsIgnoreHostFlags(t *testing.T) {
+	dir := t.TempDir()
+	file := writeFile(t, dir, "config.toml", "[server]\naddress = \":8080\"\n")
+	other := writeFile(t, dir, "other.toml", "[server]\naddress = \":9090\"\n")
+
+	conf, _, err := Load(Options{
+		DefaultFile: file,
+		DisableEnv:  true,
+		Args:        []string{"-verbose", "--port", "1234", "--config=" + other, "serve", "-set", "server.name=api", "--", "-set", "server.x=y"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := block(t, conf, "server")
+	if server["address"] != ":9090" || server["name"] != "api" {
+		t.Errorf("server = %v", server)
+	}
+	if _, ok := server["x"]; ok {
+		t.Error("arguments after -- were read")
+	}
+}
+
+func TestArgsErrors(t *testing.T) {
+	for _, args := range [][]string{{"-set"}, {"-set", "novalue"}, {"--env"}} {
+		_, _, err := Load(Options{DisableEnv: true, Args: args})
+		if err == nil {
+			t.Errorf("%v: no error", args)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+
+	"github.com/karotte128/karotteapi"
+)
+
+// decoders maps the file extension to the decoder of the format.
+// The decoders use map[string]any, so nested maps are map[string]any as well.
+var decoders = map[string]func(data []byte) (karotteapi.Config, error){
+	".toml": decodeTOML,
+	".yaml": decodeYAML,
+	".yml":  decodeYAML,
+	".json": decodeJSON,
+}
+
+func decodeTOML(data []byte) (karotteapi.Config, error) {
+	conf := map[string]any{}
+	err := toml.Unmarshal(data, &conf)
+	return conf, err
+}
+
+func decodeYAML(data []byte) (karotteapi.Config, error) {
+	conf := map[string]any{}
+	err := yaml.Unmarshal(data, &conf)
+	return conf, err
+}
+
+func decodeJSON(data []byte) (karotteapi.Config, error) {
+	conf := map[string]any{}
+	err := json.Unmarshal(data, &conf)
+	return conf, err
+}
+
+// parseValue converts a string from an environment variable or flag to a config value.
+// The value is converted to the type of the existing value at the same path, so "123456"
+// stays a string if a file set a string there. Without an existing value, the type is
+// inferred with inferValue. A value in JSON quotes (e.g. "\"123456\"") is always a string.
+func parseValue(value string, existing any) (any, error) {
+	if s, ok := quotedString(value); ok {
+		return s, nil
+	}
+
+	switch existing.(type) {
+	case nil:
+		return inferValue(value), nil
+
+	case bool:
+		switch strings.ToLower(value) {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		}
+		return nil, errors.New("must be true or false")
+
+	case int64:
+		i, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, errors.New("must be an integer")
+		}
+		return i, nil
+
+	case int:
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, errors.New("must be an integer")
+		}
+		return i, nil
+
+	case float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, errors.New("must be a number")
+		}
+		return f, nil
+
+	case []any:
+		var list []any
+		err := json.Unmarshal([]byte(value), &list)
+		if err != nil {
+			return nil, errors.New("must be a JSON array")
+		}
+		return list, nil
+	}
+
+	return value, nil
+}
+
+// floatLiteral matches decimal numbers without exponent and leading zeros.
+var floatLiteral = regexp.MustCompile(`^-?(0|[1-9][0-9]*)\.[0-9]+$`)
+
+// inferValue converts a value without an existing value at its path.
+// Only the literals true and false, integers and decimal numbers in their plain form and JSON
+// arrays are converted, so e.g. "007", "+1", "1e3" or "yes" stay strings.
+func inferValue(value string) any {
+	switch value {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+
+	if i, err := strconv.ParseInt(value, 10, 64); err == nil && strconv.FormatInt(i, 10) == value {
+		return i
+	}
+
+	if floatLiteral.MatchString(value) {
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			return f
+		}
+	}
+
+	if strings.HasPrefix(value, "[") {
+		var list []any
+		if err := json.Unmarshal([]byte(value), &list); err == nil {
+			return list
+		}
+	}
+
+	return value
+}
+
+// quotedString returns the content of a value in JSON quotes.
+func quotedString(value string) (string, bool) {
+	if len(value) < 2 || value[0] != '"' || value[len(value)-1] != '"' {
+		return "", false
+	}
+
+	var s string
+	err := json.Unmarshal([]byte(value), &s)
+	return s, err == nil
+}
@@ -0,0 +1,107 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/karotte128/karotteapi"
+)
+
+// merge merges src into dst. Nested maps are merged, all other values are replaced.
+// The source of every merged value is recorded in sources.
+func merge(dst karotteapi.Config, src map[string]any, prefix string, source string, sources Sources) {
+	for key, value := range src {
+		path := joinPath(prefix, key)
+
+		srcMap, srcIsMap := value.(map[string]any)
+		dstMap, dstIsMap := dst[key].(map[string]any)
+
+		if srcIsMap && dstIsMap {
+			merge(dstMap, srcMap, path, source, sources)
+			continue
+		}
+
+		clearSources(sources, path)
+		dst[key] = value
+		recordSources(value, path, source, sources)
+	}
+}
+
+// setValue parses a single value with parseValue and sets it at path inside conf.
+// Existing keys are matched case-insensitively, so KAROTTE_MIDDLEWARE__CONTENTTYPE__ENABLE
+// overwrites middleware.contentType.enable.
+func setValue(conf karotteapi.Config, path []string, raw string, source string, sources Sources) error {
+	current := map[string]any(conf)
+	var fullPath string
+
+	for i, part := range path {
+		key := matchKey(current, part)
+		fullPath = joinPath(fullPath, key)
+
+		if i == len(path)-1 {
+			value, err := parseValue(raw, current[key])
+			if err != nil {
+				return fmt.Errorf("%s: %s %w", source, fullPath, err)
+			}
+
+			clearSources(sources, fullPath)
+			current[key] = value
+			recordSources(value, fullPath, source, sources)
+			return nil
+		}
+
+		next, ok := current[key].(map[string]any)
+		if !ok {
+			clearSources(sources, fullPath)
+			next = map[string]any{}
+			current[key] = next
+		}
+		current = next
+	}
+	return nil
+}
+
+// matchKey returns the existing key of m that matches key case-insensitively.
+// If there is no such key, key is returned.
+func matchKey(m map[string]any, key string) string {
+	if _, ok := m[key]; ok {
+		return key
+	}
+
+	for existing := range m {
+		if strings.EqualFold(existing, key) {
+			return existing
+		}
+	}
+
+	return key
+}
+
+// recordSources records source for value and all nested values of value.
+func recordSources(value any, path string, source string, sources Sources) {
+	nested, ok := value.(map[string]any)
+	if !ok {
+		sources[path] = source
+		return
+	}
+
+	for key, v := range nested {
+		recordSources(v, joinPath(path, key), source, sources)
+	}
+}
+
+// clearSources removes the sources of path and all nested paths.
+func clearSources(sources Sources, path string) {
+	for key := range sources {
+		if key == path || strings.HasPrefix(key, path+".") {
+			delete(sources, key)
+		}
+	}
+}
+
+func joinPath(prefix string, key string) string {
+	if prefix == "" {
+		return key
+	}
+	return prefix + "." + key
+}
@@ -0,0 +1,63 @@
+package core
+
+import (
+	"context"
+
+	"github.com/karotte128/karotteapi/internal"
+)
+
+// ContextKey is a type-safe key for a value in the request context.
+// It is usually created once by a middleware and shared with the modules:
+//
+//	var AuthKey = core.NewContextKey[Principal]("auth")
+//
+//	ctx = AuthKey.Set(ctx, principal)  // in the middleware
+//	principal, ok := AuthKey.Get(ctx)  // in the module
+//
+// Every key created with NewContextKey is unique, keys with the same name do not share the value.
+// Values set with a ContextKey can not be read with GetRequestContext.
+type ContextKey[T any] struct {
+	id *contextKeyID
+}
+
+// contextKeyID identifies a ContextKey in the request context.
+// Every NewContextKey call allocates its own, so only copies of the same key match.
+type contextKeyID struct {
+	name string
+}
+
+// This function creates a new type-safe request context key.
+// The name is only used for debugging (see ListRequestContext), it does not identify the value.
+func NewContextKey[T any](name string) ContextKey[T] {
+	return ContextKey[T]{id: &contextKeyID{name: name}}
+}
+
+// Name returns the name of the key.
+func (k ContextKey[T]) Name() string {
+	if k.id == nil {
+		return ""
+	}
+	return k.id.name
+}
+
+// Set adds the value to the request context.
+func (k ContextKey[T]) Set(ctx context.Context, value T) context.Context {
+	return internal.SetKeyedContextValue(ctx, k.id, k.Name(), value)
+}
+
+// Get retrieves the value from the request context.
+// It returns false if no value was set or the value has a different type.
+func (k ContextKey[T]) Get(ctx context.Context) (T, bool) {
+	var zero T
+
+	value, ok := internal.GetKeyedContextValue(ctx, k.id)
+	if !ok {
+		return zero, false
+	}
+
+	typed, ok := value.(T)
+	if !ok {
+		return zero, false
+	}
+	return typed, true
+}
@@ -0,0 +1,51 @@
+package core
+
+import (
+	"context"
+	"testing"
+
+	"github.com/karotte128/karotteapi"
+)
+
+func TestContextKeysWithSameNameDoNotCollide(t *testing.T) {
+	first := NewContextKey[string]("user")
+	second := NewContextKey[string]("user")
+	number := NewContextKey[int]("user")
+
+	ctx := first.Set(context.Background(), "alice")
+	ctx = second.Set(ctx, "bob")
+	ctx = number.Set(ctx, 42)
+
+	if got, ok := first.Get(ctx); !ok || got != "alice" {
+		t.Errorf("first = %q, %v", got, ok)
+	}
+	if got, ok := second.Get(ctx); !ok || got != "bob" {
+		t.Errorf("second = %q, %v", got, ok)
+	}
+	if got, ok := number.Get(ctx); !ok || got != 42 {
+		t.Errorf("number = %d, %v", got, ok)
+	}
+
+	// copies of a key share the value
+	copied := first
+	if got, _ := copied.Get(ctx); got != "alice" {
+		t.Errorf("copy = %q", got)
+	}
+}
+
+func TestContextKeyIsSeparateFromRequestContext(t *testing.T) {
+	key := NewContextKey[string]("user")
+
+	ctx := key.Set(context.Background(), "alice")
+	if _, ok := LookupRequestContext(ctx, "user"); ok {
+		t.Error("the value of a context key is visible by name")
+	}
+
+	ctx = SetRequestContext(ctx, &karotteapi.RequestContext{ContextKey: "user", Info: "bob"})
+	if got, _ := key.Get(ctx); got != "alice" {
+		t.Errorf("key = %q", got)
+	}
+	if got := GetRequestContext(ctx, "user"); got.Info != "bob" {
+		t.Errorf("request context = %v", got.Info)
+	}
+}
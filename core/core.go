@@ -2,8 +2,9 @@ package core
 
 import (
 	"context"
-
-	cfg "github.com/karotte128/karottelib/config"
+	"errors"
+	"fmt"
+	"net/http"
 
 	"github.com/karotte128/karotteapi"
 	"github.com/karotte128/karotteapi/internal"
@@ -15,6 +16,111 @@ func GetModuleConfig(moduleName string) (karotteapi.Config, bool) {
 	return internal.GetModuleConfig(moduleName)
 }
 
+// This function returns the config of a middleware.
+// It should be used in a middleware for configurable values.
+func GetMiddlewareConfig(middlewareName string) (karotteapi.Config, bool) {
+	return internal.GetMiddlewareConfig(middlewareName)
+}
+
+// This function replaces the config at runtime.
+// Running modules and applied middleware whose config block changed are notified via OnConfigChange.
+// Changes to the server config are only applied after a restart.
+func ReloadConfig(config karotteapi.Config) error {
+	return internal.ReloadConfig(config)
+}
+
+// This function sets the function used to fetch a fresh config when the server receives SIGHUP.
+func SetConfigSource(source func() (karotteapi.Config, error)) {
+	internal.SetConfigSource(source)
+}
+
+// This function fetches a fresh config from the config source and applies it.
+func ReloadConfigFromSource() error {
+	return internal.ReloadConfigFromSource()
+}
+
+// This function registers a provider that resolves "secret://<name>/<reference>" config values.
+// It must be called before the config is loaded, i.e. before api.InitAPI.
+// The providers "file" and "env" are built in.
+func RegisterSecretProvider(name string, provider karotteapi.SecretProvider) {
+	internal.RegisterSecretProvider(name, provider)
+}
+
+// This function registers an authenticator for the auth middleware.
+// The factory receives the config block [middleware.auth.<name>].
+// The authenticator is used if its name is listed in [middleware.auth] authenticators.
+func RegisterAuthenticator(name string, factory karotteapi.AuthenticatorFactory) {
+	internal.RegisterAuthenticator(name, factory)
+}
+
+// This function returns the authenticator factory registered under the name.
+func GetAuthenticatorFactory(name string) (karotteapi.AuthenticatorFactory, bool) {
+	return internal.GetAuthenticatorFactory(name)
+}
+
+// This function adds the authenticated principal to the request context.
+// It is usually used by an authentication middleware.
+func SetPrincipal(ctx context.Context, principal *karotteapi.Principal) context.Context {
+	return internal.SetPrincipal(ctx, principal)
+}
+
+// This function retrieves the authenticated principal from the request context.
+// It returns false if the request is not authenticated.
+func GetPrincipal(ctx context.Context) (*karotteapi.Principal, bool) {
+	return internal.GetPrincipal(ctx)
+}
+
+// This function retrieves the request id set by the requestid middleware.
+// It returns an empty string if the request has no id.
+func GetRequestID(ctx context.Context) string {
+	return internal.GetRequestID(ctx)
+}
+
+// This function adds the request id to the request context.
+// It is usually used by the requestid middleware.
+func SetRequestID(ctx context.Context, id string) context.Context {
+	return internal.SetRequestID(ctx, id)
+}
+
+// This function sets the store used by the ratelimit middleware.
+// By default, the rate limits are kept in memory.
+func SetRateLimitStore(store karotteapi.RateLimitStore) {
+	internal.SetRateLimitStore(store)
+}
+
+// This function returns the store used by the ratelimit middleware.
+func GetRateLimitStore() karotteapi.RateLimitStore {
+	return internal.GetRateLimitStore()
+}
+
+// This function creates an in-memory rate limit store.
+// It supports the "token_bucket" and "sliding_window" algorithms and is only suitable for a single instance.
+// It holds at most maxKeys keys (100000 if maxKeys is 0) and evicts the least recently used key when it is full.
+func NewMemoryRateLimitStore(maxKeys int) karotteapi.RateLimitStore {
+	return internal.NewMemoryRateLimitStore(maxKeys)
+}
+
+// This function returns the name of the module that serves the request.
+// It can be used in a middleware for per-module behaviour.
+func GetModuleForRequest(r *http.Request) (string, bool) {
+	return internal.GetModuleForPath(r.URL.Path)
+}
+
+// This function returns the current config with all secret references
+// and the values of sensitive keys redacted: password, token, secret, keys, *_key, hash,
+// authorization, credential and their plural forms, with all nested values and keys.
+// Bcrypt hashes are redacted as well. A bare "key" (e.g. the ratelimit key) is not redacted.
+// It should be used whenever the config is logged or exposed.
+func GetRedactedConfig() karotteapi.Config {
+	return internal.GetRedactedConfig()
+}
+
+// This function returns the effective config (redacted), which config blocks
+// were consumed by which modules and middleware, and which keys were never read.
+func GetConfigReport() karotteapi.ConfigReport {
+	return internal.GetConfigReport()
+}
+
 // This function should be used inside the init() function of each middleware.
 // It adds the middleware to the middleware registry.
 func RegisterMiddleware(middleware karotteapi.Middleware) {
@@ -31,7 +137,17 @@ func RegisterModule(module karotteapi.Module) {
 // Input the config and the config path.
 // Type specifies the type of the return value.
 func GetNestedValue[Type any](m karotteapi.Config, path ...string) (Type, bool) {
-	return cfg.GetNestedValue[Type](m, path...)
+	return internal.GetNestedValue[Type](m, path...)
+}
+
+// This function can be used to get a config value with a fallback.
+// If the value does not exist or has a different type, defaultValue is returned.
+func GetNestedValueOrDefault[Type any](m karotteapi.Config, defaultValue Type, path ...string) Type {
+	value, ok := internal.GetNestedValue[Type](m, path...)
+	if !ok {
+		return defaultValue
+	}
+	return value
 }
 
 // This function adds additional info to the request context.
@@ -42,6 +158,59 @@ func SetRequestContext(ctx context.Context, info *karotteapi.RequestContext) con
 
 // This function retrieves the additional info from the request context.
 // It is usually used in a module.
+// If no info was set, Info is nil. Use LookupRequestContext to check if the info was set.
 func GetRequestContext(ctx context.Context, contextKey string) karotteapi.RequestContext {
 	return internal.GetRequestContext(ctx, contextKey)
 }
+
+// This function retrieves the additional info from the request context.
+// It returns false if no info was set (e.g. the middleware setting it did not run).
+func LookupRequestContext(ctx context.Context, contextKey string) (karotteapi.RequestContext, bool) {
+	return internal.LookupRequestContext(ctx, contextKey)
+}
+
+// ErrRequestValueType is returned by GetRequestValue if the value has a different type.
+var ErrRequestValueType = errors.New("request context value has a different type")
+
+// This function retrieves a typed value from the request context.
+// It returns false if no value was set, and ErrRequestValueType if the value has a different type.
+func GetRequestValue[Type any](ctx context.Context, contextKey string) (Type, bool, error) {
+	var zero Type
+
+	value, ok := internal.GetContextValue(ctx, contextKey)
+	if !ok {
+		return zero, false, nil
+	}
+
+	typed, ok := value.(Type)
+	if !ok {
+		return zero, true, fmt.Errorf("%w: %s is %T, not %T", ErrRequestValueType, contextKey, value, zero)
+	}
+	return typed, true, nil
+}
+
+// This function returns all values set in the request context through the framework, in the order they were set.
+// It only works if the contextDebug middleware is enabled, otherwise it returns nil.
+func ListRequestContext(ctx context.Context) []karotteapi.RequestContext {
+	return internal.ListRequestContext(ctx)
+}
+
+// This function registers a function that is called for every panic caught by the recovery middleware,
+// e.g. to forward it to an error tracker. The hook is called before the response is written,
+// so it should not block for long.
+func OnPanic(hook func(info karotteapi.PanicInfo)) {
+	internal.RegisterPanicHook(hook)
+}
+
+// This function writes the error as RFC 9457 problem details ("application/problem+json").
+// A *karotteapi.HTTPError is written with its status, code, detail and fields.
+// Any other error is logged and written as 500 without details.
+func WriteError(w http.ResponseWriter, r *http.Request, err error) {
+	internal.WriteError(w, r, err)
+}
+
+// This function creates an HTTPError that can be returned to WriteError.
+// The code is a machine-readable error code, the detail is shown to the client.
+func NewHTTPError(status int, code string, detail string) *karotteapi.HTTPError {
+	return internal.NewHTTPError(status, code, detail)
+}
@@ -0,0 +1,141 @@
+package core_test
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/karotte128/karotteapi"
+	"github.com/karotte128/karotteapi/core"
+)
+
+// The examples mirror the snippets of the README, so they keep compiling.
+
+func ExampleRegisterModule() {
+	routes := func() (string, http.Handler) {
+		mux := http.NewServeMux() // Create the new http mux.
+		mux.HandleFunc("/example", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, "Hello World!") // Write the http response.
+		})
+		return "/example", mux // Return prefix and mux.
+	}
+
+	exampleModule := karotteapi.Module{
+		Name:   "example",
+		Routes: routes,
+		Startup: func() error {
+			core.Logger("example").Info("starting the example module")
+			return nil
+		},
+		Shutdown: func() error {
+			core.Logger("example").Info("shutting down the example module")
+			return nil
+		},
+	}
+
+	core.RegisterModule(exampleModule)
+}
+
+func ExampleRegisterMiddleware() {
+	exampleHandler := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Example-Header", "Hello World!")
+			next.ServeHTTP(w, r)
+		})
+	}
+
+	exampleMiddleware := karotteapi.Middleware{
+		Name:        "example",
+		Handler:     exampleHandler,
+		Priority:    10,
+		ForceEnable: false,
+	}
+
+	core.RegisterMiddleware(exampleMiddleware)
+}
+
+func ExampleGetMiddlewareConfig() {
+	core.ReloadConfig(karotteapi.Config{
+		"middleware": map[string]any{
+			"example": map[string]any{"header_value": "Hello config!"},
+		},
+	})
+
+	exampleHandler := func(next http.Handler) http.Handler {
+		conf, _ := core.GetMiddlewareConfig("example") // Get the [middleware.example] config block.
+		value := core.GetNestedValueOrDefault(conf, "Hello World!", "header_value")
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Example-Header", value)
+			next.ServeHTTP(w, r)
+		})
+	}
+
+	recorder := httptest.NewRecorder()
+	exampleHandler(http.NotFoundHandler()).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+	fmt.Println(recorder.Header().Get("Example-Header"))
+	// Output: Hello config!
+}
+
+func ExampleGetNestedValueOrDefault() {
+	conf := karotteapi.Config{
+		"header_value": "Hello!",
+		"limits":       map[string]any{"burst": int64(20)},
+	}
+
+	fmt.Println(core.GetNestedValueOrDefault(conf, "Hello World!", "header_value"))
+	fmt.Println(core.GetNestedValueOrDefault(conf, int64(10), "limits", "burst"))
+	fmt.Println(core.GetNestedValueOrDefault(conf, int64(10), "limits", "rate"))
+	// a value of a different type also falls back to the default
+	fmt.Println(core.GetNestedValueOrDefault(conf, 1.5, "header_value"))
+	// Output:
+	// Hello!
+	// 20
+	// 10
+	// 1.5
+}
+
+func ExampleNewContextKey() {
+	UserKey := core.NewContextKey[string]("user") // Create the key once and share it with the modules.
+
+	userHandler := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := UserKey.Set(r.Context(), "alice") // Add the value to the request context.
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+
+	example := func(w http.ResponseWriter, r *http.Request) {
+		user, ok := UserKey.Get(r.Context()) // Get the value, no type assertion needed.
+		if !ok {
+			http.Error(w, "no user", http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, "Hello "+user+"!")
+	}
+
+	recorder := httptest.NewRecorder()
+	userHandler(http.HandlerFunc(example)).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+	fmt.Println(recorder.Body.String())
+	// Output: Hello alice!
+}
+
+func ExampleNewResponseWriter() {
+	timingHandler := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rw := core.NewResponseWriter(w)
+			next.ServeHTTP(rw, r)
+
+			slog.Info("request", "status", rw.Status(), "bytes", rw.Bytes(), "duration", rw.Duration())
+			fmt.Println(rw.Status(), rw.Bytes())
+		})
+	}
+
+	handler := timingHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprint(w, "created")
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", nil))
+	// Output: 201 7
+}
@@ -0,0 +1,27 @@
+package core
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/karotte128/karotteapi/internal"
+)
+
+// This function turns a typed function into an http.Handler.
+// The request struct is bound from the request and validated before fn is called:
+//
+//	type GetOrders struct {
+//		Customer string `path:"customer" json:"-"`
+//		Limit    int    `query:"limit" json:"-" validate:"max=100"`
+//		Tenant   string `header:"X-Tenant" json:"-" validate:"required"`
+//	}
+//
+//	mux.Handle("GET /orders/{customer}", core.Handle(getOrders))
+//
+// Fields with a path, query or header tag are bound from the parameter, all other fields
+// from the JSON body (see DecodeJSON). The response is written with WriteResponse and status 200,
+// unless it has a method StatusCode() int. Returned errors are written with WriteError.
+// It panics if Req is not a struct or has parameter fields of an unsupported type.
+func Handle[Req, Resp any](fn func(ctx context.Context, req Req) (Resp, error)) http.Handler {
+	return internal.Handle(fn)
+}
@@ -0,0 +1,52 @@
+package core
+
+import (
+	"net/http"
+
+	"github.com/karotte128/karotteapi"
+	"github.com/karotte128/karotteapi/internal"
+)
+
+// This function reads the JSON body of the request into v and validates it with Validate.
+// The body must not be larger than 1 MiB, contain unknown fields or more than one value.
+// The returned errors can be passed to WriteError:
+//
+//	var req CreateOrder
+//	if err := core.DecodeJSON(w, r, &req); err != nil {
+//		core.WriteError(w, r, err)
+//		return
+//	}
+func DecodeJSON(w http.ResponseWriter, r *http.Request, v any) error {
+	return internal.DecodeJSON(w, r, v, internal.DefaultMaxBodySize)
+}
+
+// This function works like DecodeJSON, but with a custom maximum body size in bytes.
+func DecodeJSONLimit(w http.ResponseWriter, r *http.Request, v any, maxBytes int64) error {
+	return internal.DecodeJSON(w, r, v, maxBytes)
+}
+
+// This function checks a struct against the validate tags of its fields
+// (required, min, max, enum and regex). It returns an HTTPError with status 422
+// that lists every invalid field.
+func Validate(v any) error {
+	return internal.Validate(v)
+}
+
+// This function writes v as JSON response with the status.
+// If v can not be encoded, a 500 error is written instead and the error is returned.
+func WriteJSON(w http.ResponseWriter, status int, v any) error {
+	return internal.WriteJSON(w, status, v)
+}
+
+// This function writes v with the encoder that matches the Accept header of the request best
+// (JSON, XML, MessagePack, CBOR, CSV or a registered encoder). JSON is used if the client accepts anything.
+// If the client accepts none of them, a 406 error is written and returned.
+func WriteResponse(w http.ResponseWriter, r *http.Request, status int, v any) error {
+	return internal.WriteResponse(w, r, status, v)
+}
+
+// This function registers an encoder for a media type that can be selected with the Accept header.
+// An encoder registered for the same media type before (including the builtin ones) is replaced.
+func RegisterEncoder(mediaType string, encoder karotteapi.Encoder) {
+	internal.RegisterEncoder(mediaType, encoder)
+}
@@ -0,0 +1,27 @@
+package core
+
+import (
+	"log/slog"
+
+	"github.com/karotte128/karotteapi/internal"
+)
+
+// This function returns a logger for a module.
+// Every record contains the module name. Records logged with a request context
+// (e.g. logger.InfoContext(r.Context(), ...)) also contain the request id.
+func Logger(moduleName string) *slog.Logger {
+	return internal.ModuleLogger(moduleName)
+}
+
+// This function returns a logger for a middleware.
+// Every record contains the middleware name.
+func MiddlewareLogger(middlewareName string) *slog.Logger {
+	return internal.MiddlewareLogger(middlewareName)
+}
+
+// This function replaces the log handler of the framework, e.g. to use the handler of the host application.
+// It should be called before the server starts, loggers retrieved before keep the old handler.
+// The log_level and log_format values of the server config are ignored afterwards.
+func SetLogHandler(handler slog.Handler) {
+	internal.SetLogHandler(handler)
+}
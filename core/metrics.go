@@ -0,0 +1,13 @@
+package core
+
+import (
+	"github.com/karotte128/karotteapi/internal"
+	"github.com/karotte128/karotteapi/metrics"
+)
+
+// This function returns the metrics registry of the framework.
+// Modules can register their own counters, gauges and histograms in it.
+// The metrics module exposes all metrics of the registry.
+func Metrics() *metrics.Registry {
+	return internal.Metrics()
+}
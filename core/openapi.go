@@ -0,0 +1,10 @@
+package core
+
+import "github.com/karotte128/karotteapi/internal"
+
+// This function generates the OpenAPI 3.1 document of the running modules as JSON.
+// It is built from the Operations of every module. Before the server is started,
+// it contains all modules enabled in the config.
+func OpenAPI() ([]byte, error) {
+	return internal.OpenAPI()
+}
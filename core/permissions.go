@@ -0,0 +1,83 @@
+package core
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/karotte128/karotteapi"
+	"github.com/karotte128/karotteapi/config"
+	"github.com/karotte128/karotteapi/internal"
+)
+
+// This function sets the provider used to check permissions.
+// The authorization middleware sets a role based provider from its config if it is enabled.
+func SetPermissionProvider(provider karotteapi.PermissionProvider) {
+	internal.SetPermissionProvider(provider)
+}
+
+// This function wraps a handler of a module route, so it is only called
+// if the principal of the request has the permission.
+// Requests without principal are rejected with 401, requests without permission with 403.
+//
+// Example:
+//
+//	mux.Handle("POST /orders", core.Require("orders:write", http.HandlerFunc(createOrder)))
+func Require(permission string, handler http.Handler) http.Handler {
+	return internal.Require(handler, permission)
+}
+
+// This function checks if the principal of the request has all permissions.
+// If not, it writes the error response (401, 403 or 500) and returns false.
+func Authorize(w http.ResponseWriter, r *http.Request, permissions ...string) bool {
+	return internal.Authorize(w, r, permissions...)
+}
+
+// This function reports whether the principal of the request has the permission.
+// It can be used inside a handler for checks that depend on the request.
+func HasPermission(r *http.Request, permission string) (bool, error) {
+	principal, ok := GetPrincipal(r.Context())
+	if !ok {
+		return false, nil
+	}
+	return internal.HasPermissions(principal, permission)
+}
+
+// This function creates a permission provider that grants permissions by the roles of the principal.
+// Permissions can use wildcards: "*" grants everything, "orders:*" grants every permission starting with "orders:".
+func NewRolePermissionProvider(roles map[string][]string) karotteapi.PermissionProvider {
+	return internal.NewRolePermissionProvider(roles)
+}
+
+// This function creates a role based permission provider from a config file (.toml, .yaml, .yml or .json).
+// The file contains a "roles" table with the permissions of each role:
+//
+//	[roles]
+//	admin = ["*"]
+//	reader = ["orders:read"]
+func NewRoleFilePermissionProvider(path string) (karotteapi.PermissionProvider, error) {
+	conf, err := config.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	roles, err := ReadRoles(conf)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return internal.NewRolePermissionProvider(roles), nil
+}
+
+// This function reads the "roles" table of a config block as role name to permissions.
+func ReadRoles(conf karotteapi.Config) (map[string][]string, error) {
+	table, _ := GetNestedValue[map[string]any](conf, "roles")
+
+	roles := map[string][]string{}
+	for role := range table {
+		permissions, ok := GetNestedValue[[]string](table, role)
+		if !ok {
+			return nil, fmt.Errorf("permissions of role %s are not a list of strings", role)
+		}
+		roles[role] = permissions
+	}
+	return roles, nil
+}
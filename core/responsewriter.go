@@ -0,0 +1,127 @@
+package core
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+// ResponseWriter wraps an http.ResponseWriter and records the status code, the size and the
+// duration of the response. The optional interfaces of the wrapped writer keep working:
+// http.Flusher, http.Hijacker, http.Pusher and io.ReaderFrom. Unwrap lets http.ResponseController
+// reach the writer of the server, e.g. to set deadlines.
+//
+//	rw := core.NewResponseWriter(w)
+//	next.ServeHTTP(rw, r)
+//	logger.Info("request", "status", rw.Status(), "bytes", rw.Bytes(), "duration", rw.Duration())
+//
+// Middleware that change the response body can embed *ResponseWriter, but must override Write and ReadFrom.
+type ResponseWriter struct {
+	http.ResponseWriter
+
+	start    time.Time
+	status   int
+	bytes    int64
+	hijacked bool
+}
+
+// This function wraps w in a ResponseWriter. The duration of the response is measured from now.
+func NewResponseWriter(w http.ResponseWriter) *ResponseWriter {
+	return &ResponseWriter{ResponseWriter: w, start: time.Now()}
+}
+
+// WriteHeader records the status code. Informational (1xx) responses are passed on without being recorded.
+func (rw *ResponseWriter) WriteHeader(status int) {
+	if status >= 200 && rw.status == 0 {
+		rw.status = status
+	}
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+// Write records the number of written bytes.
+func (rw *ResponseWriter) Write(b []byte) (int, error) {
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+	n, err := rw.ResponseWriter.Write(b)
+	rw.bytes += int64(n)
+	return n, err
+}
+
+// ReadFrom copies the data with the io.ReaderFrom of the wrapped writer if it has one,
+// so e.g. http.ServeContent can still use sendfile.
+func (rw *ResponseWriter) ReadFrom(src io.Reader) (int64, error) {
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+	n, err := io.Copy(rw.ResponseWriter, src)
+	rw.bytes += n
+	return n, err
+}
+
+// Flush sends the buffered data to the client. It does nothing if the wrapped writer can not flush.
+func (rw *ResponseWriter) Flush() {
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+	http.NewResponseController(rw.ResponseWriter).Flush()
+}
+
+// Hijack takes over the connection, e.g. for WebSockets.
+func (rw *ResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, buf, err := http.NewResponseController(rw.ResponseWriter).Hijack()
+	if err == nil {
+		rw.hijacked = true
+	}
+	return conn, buf, err
+}
+
+// Push starts an HTTP/2 server push. It returns http.ErrNotSupported if the wrapped writer can not push.
+func (rw *ResponseWriter) Push(target string, opts *http.PushOptions) error {
+	pusher, ok := rw.ResponseWriter.(http.Pusher)
+	if !ok {
+		return http.ErrNotSupported
+	}
+	return pusher.Push(target, opts)
+}
+
+// Unwrap returns the wrapped writer. It is used by http.ResponseController.
+func (rw *ResponseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// Status returns the status code of the response.
+// It is 200 if the handler did not set one, like net/http does.
+func (rw *ResponseWriter) Status() int {
+	if rw.status == 0 {
+		return http.StatusOK
+	}
+	return rw.status
+}
+
+// Written reports whether the response was started, i.e. the status was sent or the connection was hijacked.
+func (rw *ResponseWriter) Written() bool {
+	return rw.status != 0 || rw.hijacked
+}
+
+// Hijacked reports whether the connection was hijacked.
+func (rw *ResponseWriter) Hijacked() bool {
+	return rw.hijacked
+}
+
+// Bytes returns the number of body bytes written to the wrapped writer.
+func (rw *ResponseWriter) Bytes() int64 {
+	return rw.bytes
+}
+
+// Start returns the time the ResponseWriter was created.
+func (rw *ResponseWriter) Start() time.Time {
+	return rw.start
+}
+
+// Duration returns the time since the ResponseWriter was created.
+func (rw *ResponseWriter) Duration() time.Duration {
+	return time.Since(rw.start)
+}
@@ -0,0 +1,29 @@
+package core
+
+import (
+	"context"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/karotte128/karotteapi"
+	"github.com/karotte128/karotteapi/internal"
+)
+
+// This function returns the OpenTelemetry tracer of the framework from the global tracer provider.
+// If tracing is disabled, its spans only propagate the trace context.
+func Tracer() trace.Tracer {
+	return internal.Tracer()
+}
+
+// This function starts a span as child of the current span of ctx (e.g. the request span).
+// The span must be ended with span.End().
+func StartSpan(ctx context.Context, name string) (context.Context, trace.Span) {
+	return internal.StartSpan(ctx, name)
+}
+
+// This function registers a span exporter that can be selected with exporter = "<name>" in [server.tracing].
+// The factory receives the [server.tracing] config block.
+func RegisterTraceExporter(name string, factory func(config karotteapi.Config) (sdktrace.SpanExporter, error)) {
+	internal.RegisterTraceExporter(name, factory)
+}
@@ -0,0 +1,55 @@
+package internal
+
+import (
+	"context"
+	"sync"
+
+	"github.com/karotte128/karotteapi"
+)
+
+// PrincipalContextKey is the request context key of the authenticated principal.
+const PrincipalContextKey = "auth"
+
+// authenticator_registry holds all registered authenticator factories by name.
+var authenticator_registry = map[string]karotteapi.AuthenticatorFactory{}
+
+// authenticatorLock guards authenticator_registry.
+var authenticatorLock sync.RWMutex
+
+// RegisterAuthenticator registers an authenticator factory under a name.
+// A factory registered under an existing name replaces it.
+func RegisterAuthenticator(name string, factory karotteapi.AuthenticatorFactory) {
+	authenticatorLock.Lock()
+	defer authenticatorLock.Unlock()
+
+	authenticator_registry[name] = factory
+}
+
+// GetAuthenticatorFactory returns the authenticator factory registered under the name.
+func GetAuthenticatorFactory(name string) (karotteapi.AuthenticatorFactory, bool) {
+	authenticatorLock.RLock()
+	defer authenticatorLock.RUnlock()
+
+	factory, ok := authenticator_registry[name]
+	return factory, ok
+}
+
+// SetPrincipal adds the principal to the request context.
+func SetPrincipal(ctx context.Context, principal *karotteapi.Principal) context.Context {
+	return SetRequestContext(ctx, &karotteapi.RequestContext{
+		Info:       principal,
+		ContextKey: PrincipalContextKey,
+	})
+}
+
+// GetPrincipal retrieves the principal from the request context.
+// It returns false if the request is not authenticated.
+func GetPrincipal(ctx context.Context) (*karotteapi.Principal, bool) {
+	value, ok := GetContextValue(ctx, PrincipalContextKey)
+	if !ok {
+		return nil, false
+	}
+
+	principal, ok := value.(*karotteapi.Principal)
+	return principal, ok && principal != nil
+}
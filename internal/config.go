@@ -1,7 +1,11 @@
 package internal
 
 import (
-	cfg "github.com/karotte128/karottelib/config"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
 
 	"github.com/karotte128/karotteapi"
 )
@@ -9,33 +13,214 @@ import (
 // Global config instance
 var config karotteapi.Config
 
-func LoadConfig(conf karotteapi.Config) {
+// resolvedConfig is config with all secret references resolved.
+var resolvedConfig karotteapi.Config
+
+// configLock guards config and resolvedConfig. The config is replaced as a whole on reload,
+// so readers holding an old block keep a consistent view of it.
+var configLock sync.RWMutex
+
+// configSource is used to fetch a fresh config on reload (e.g. on SIGHUP).
+var configSource func() (karotteapi.Config, error)
+
+// reloadLock makes sure only one reload runs at a time.
+var reloadLock sync.Mutex
+
+// LoadConfig sets the config. It fails if a secret reference can not be resolved.
+func LoadConfig(conf karotteapi.Config) error {
+	resolved, err := resolveConfig(conf)
+	if err != nil {
+		return fmt.Errorf("failed resolving secrets: %w", err)
+	}
+
+	configLock.Lock()
 	config = conf
+	resolvedConfig = resolved
+	configLock.Unlock()
+
+	trackConfig(resolved)
+	return nil
 }
 
-// GetModuleConfig returns the raw config block for a module.
+// getConfig returns the current config instance.
+func getConfig() karotteapi.Config {
+	configLock.RLock()
+	defer configLock.RUnlock()
+
+	return config
+}
+
+// getResolvedConfig returns the current config with all secret references resolved.
+func getResolvedConfig() karotteapi.Config {
+	configLock.RLock()
+	defer configLock.RUnlock()
+
+	return resolvedConfig
+}
+
+// GetModuleConfig returns the config block for a module with all secrets resolved.
 func GetModuleConfig(moduleName string) (karotteapi.Config, bool) {
-	if config == nil {
+	return getResolvedBlock("modules", moduleName)
+}
+
+// GetMiddlewareConfig returns the config block for a middleware with all secrets resolved.
+func GetMiddlewareConfig(middlewareName string) (karotteapi.Config, bool) {
+	return getResolvedBlock("middleware", middlewareName)
+}
+
+// GetServerConfig returns the server config with all secrets resolved.
+func GetServerConfig() (karotteapi.Config, bool) {
+	return getResolvedBlock("server")
+}
+
+// getConfigBlock returns the config block at path inside conf.
+// Keys that only differ in case are matched as well, because environment variables
+// can only create lower case keys (e.g. middleware.contenttype for the contentType middleware).
+func getConfigBlock(conf karotteapi.Config, path ...string) (karotteapi.Config, bool) {
+	if conf == nil {
 		return nil, false
 	}
 
-	return cfg.GetNestedValue[map[string]any](config, "modules", moduleName)
+	current := map[string]any(conf)
+	for _, key := range path {
+		value, ok := current[key]
+		if !ok {
+			for existing, v := range current {
+				if strings.EqualFold(existing, key) {
+					value, ok = v, true
+					break
+				}
+			}
+		}
+
+		current, ok = value.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// SetConfigSource sets the function used to fetch a fresh config on reload.
+func SetConfigSource(source func() (karotteapi.Config, error)) {
+	reloadLock.Lock()
+	defer reloadLock.Unlock()
+
+	configSource = source
 }
 
-// GetMiddlewareConfig returns the raw config block for a module.
-func GetMiddlewareConfig(middlewareName string) (karotteapi.Config, bool) {
-	if config == nil {
-		return nil, false
+// ReloadConfigFromSource fetches a new config from the config source and applies it.
+func ReloadConfigFromSource() error {
+	reloadLock.Lock()
+	source := configSource
+	reloadLock.Unlock()
+
+	if source == nil {
+		return errors.New("no config source set")
 	}
 
-	return cfg.GetNestedValue[map[string]any](config, "middleware", middlewareName)
+	conf, err := source()
+	if err != nil {
+		return fmt.Errorf("failed loading config: %w", err)
+	}
+
+	return ReloadConfig(conf)
 }
 
-// GetServerConfig returns the server config.
-func GetServerConfig() (karotteapi.Config, bool) {
-	if config == nil {
-		return nil, false
+// ReloadConfig atomically replaces the config and notifies every running module
+// and applied middleware whose config block changed.
+// If a secret reference can not be resolved, the old config is kept and the error is returned.
+// Otherwise the returned error joins all errors returned by the OnConfigChange hooks.
+func ReloadConfig(conf karotteapi.Config) error {
+	reloadLock.Lock()
+	defer reloadLock.Unlock()
+
+	// the old config stays in place if a secret can not be resolved
+	resolved, err := resolveConfig(conf)
+	if err != nil {
+		return fmt.Errorf("failed resolving secrets: %w", err)
+	}
+
+	configLock.Lock()
+	oldConf := resolvedConfig
+	config = conf
+	resolvedConfig = resolved
+	configLock.Unlock()
+
+	trackConfig(resolved)
+
+	var errs []error
+
+	// The server block is only read on startup.
+	oldServer, _ := getConfigBlock(oldConf, "server")
+	newServer, _ := getConfigBlock(resolved, "server")
+	if !reflect.DeepEqual(oldServer, newServer) {
+		setLogLevel(newServer)
+		Logger().Warn("server config changed, a restart is required to apply it (except log_level)")
+	}
+
+	// the hooks run without holding the registry lock, so they can use the registries themselves
+	for _, reg_mod := range moduleRegistry() {
+		if reg_mod.status != statusRunning {
+			continue
+		}
+
+		oldBlock, _ := getConfigBlock(oldConf, "modules", reg_mod.module.Name)
+		newBlock, _ := getConfigBlock(resolved, "modules", reg_mod.module.Name)
+		if reflect.DeepEqual(oldBlock, newBlock) {
+			continue
+		}
+
+		ModuleLogger(reg_mod.module.Name).Info("module config changed")
+
+		err := safeConfigChange("module", reg_mod.module.Name, reg_mod.module.OnConfigChange, oldBlock, newBlock)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	for _, middleware := range appliedMiddleware() {
+		oldBlock, _ := getConfigBlock(oldConf, "middleware", middleware.Name)
+		newBlock, _ := getConfigBlock(resolved, "middleware", middleware.Name)
+		if reflect.DeepEqual(oldBlock, newBlock) {
+			continue
+		}
+
+		MiddlewareLogger(middleware.Name).Info("middleware config changed")
+
+		err := safeConfigChange("middleware", middleware.Name, middleware.OnConfigChange, oldBlock, newBlock)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	Logger().Info("config reloaded")
+
+	return errors.Join(errs...)
+}
+
+// safeConfigChange executes an OnConfigChange hook.
+// It makes sure that a panic in the hook does not crash the server.
+func safeConfigChange(kind string, name string, hook func(oldConfig, newConfig karotteapi.Config) error, oldConf, newConf karotteapi.Config) (err error) {
+	// only execute if the hook is implemented
+	if hook == nil {
+		return nil
+	}
+
+	// recover from panic
+	defer func() {
+		r := recover()
+		if r != nil {
+			err = fmt.Errorf("%s %s panicked during config change: %v", kind, name, r)
+			Logger().Error("config change failed", kind, name, "error", err)
+		}
+	}()
+
+	err = hook(oldConf, newConf)
+	if err != nil {
+		err = fmt.Errorf("%s %s failed config change: %w", kind, name, err)
+		Logger().Error("config change failed", kind, name, "error", err)
 	}
 
-	return cfg.GetNestedValue[map[string]any](config, "server")
+	return err
 }
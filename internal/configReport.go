@@ -0,0 +1,247 @@
+package internal
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	cfg "github.com/karotte128/karottelib/config"
+
+	"github.com/karotte128/karotteapi"
+)
+
+// The config report shows the effective config and how it is used.
+// To know which keys were read, every map of the resolved config is remembered
+// by its config path when the config is loaded. GetNestedValue looks up the
+// path of the map it reads from and records the full path of the key.
+
+// sensitiveKeys are config keys whose values are redacted in the report, including all nested
+// values and their keys (e.g. the tokens of [middleware.auth.bearer.tokens]).
+// Their plural forms and keys ending in "_" or "-" followed by one of them are redacted as well
+// (e.g. secrets, db_password). A bare "key" is not sensitive, it is used for e.g. the ratelimit key.
+var sensitiveKeys = []string{"password", "passwd", "secret", "token", "keys", "apikey", "api_key", "private_key", "hash", "authorization", "credential"}
+
+// sensitiveSuffixes are suffixes of config keys whose values are redacted, in addition to sensitiveKeys.
+var sensitiveSuffixes = []string{"_key", "_keys"}
+
+// trackLock guards tracked_blocks, consumed_blocks and read_keys.
+var trackLock sync.Mutex
+
+// tracked_blocks maps the config path of every map in the resolved config to the map.
+// Holding the maps keeps them alive, so a map is never mistaken for another one.
+var tracked_blocks = map[string]map[string]any{}
+
+// consumed_blocks holds the lower case config paths of all blocks that were requested.
+var consumed_blocks = map[string]bool{}
+
+// read_keys holds the config paths of all values that were read.
+// It is kept across reloads, so values read on startup stay marked as read.
+var read_keys = map[string]bool{}
+
+// trackConfig remembers all maps of the resolved config by their config path.
+// It is called when the config is replaced.
+func trackConfig(conf karotteapi.Config) {
+	blocks := map[string]map[string]any{}
+	for key, value := range conf {
+		nested, ok := value.(map[string]any)
+		if ok {
+			collectBlocks(nested, key, blocks)
+		}
+	}
+
+	trackLock.Lock()
+	defer trackLock.Unlock()
+
+	tracked_blocks = blocks
+}
+
+func collectBlocks(m map[string]any, path string, blocks map[string]map[string]any) {
+	blocks[path] = m
+
+	for key, value := range m {
+		nested, ok := value.(map[string]any)
+		if ok {
+			collectBlocks(nested, path+"."+key, blocks)
+		}
+	}
+}
+
+// recordConsumed marks the block at path as requested.
+func recordConsumed(path string) {
+	trackLock.Lock()
+	defer trackLock.Unlock()
+
+	consumed_blocks[strings.ToLower(path)] = true
+}
+
+// recordRead marks the value at path inside m as read.
+func recordRead(m map[string]any, path []string) {
+	if m == nil {
+		return
+	}
+
+	trackLock.Lock()
+	defer trackLock.Unlock()
+
+	for blockPath, block := range tracked_blocks {
+		if sameMap(block, m) {
+			read_keys[strings.Join(append([]string{blockPath}, path...), ".")] = true
+			return
+		}
+	}
+}
+
+// sameMap reports whether a and b are the same map (not only equal).
+func sameMap(a, b map[string]any) bool {
+	return len(a) == len(b) && reflect.ValueOf(a).UnsafePointer() == reflect.ValueOf(b).UnsafePointer()
+}
+
+// GetNestedValue reads a typed value from a config block and records the read for the config report.
+func GetNestedValue[Type any](m karotteapi.Config, path ...string) (Type, bool) {
+	recordRead(m, path)
+	return cfg.GetNestedValue[Type](m, path...)
+}
+
+// isRead reports whether the value at path or one of its parents was read.
+func isRead(path string) bool {
+	trackLock.Lock()
+	defer trackLock.Unlock()
+
+	for {
+		if read_keys[path] {
+			return true
+		}
+
+		i := strings.LastIndex(path, ".")
+		if i < 0 {
+			return false
+		}
+		path = path[:i]
+	}
+}
+
+// isSensitiveKey reports whether the value of key should be redacted.
+// The whole key is matched, so e.g. the ratelimit key = "ip" is not redacted.
+func isSensitiveKey(key string) bool {
+	key = strings.ReplaceAll(strings.ToLower(key), "-", "_")
+
+	for _, sensitive := range sensitiveKeys {
+		// singular and plural forms (e.g. secrets, hashes)
+		for _, form := range []string{sensitive, sensitive + "s", sensitive + "es"} {
+			if key == form || strings.HasSuffix(key, "_"+form) {
+				return true
+			}
+		}
+	}
+	for _, suffix := range sensitiveSuffixes {
+		if strings.HasSuffix(key, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetConfigReport returns the effective config (redacted), the config blocks
+// and who they belong to, and the config keys that were never read.
+func GetConfigReport() karotteapi.ConfigReport {
+	conf := getConfig()
+
+	report := karotteapi.ConfigReport{
+		Config:     RedactConfig(conf),
+		Blocks:     []karotteapi.ConfigBlock{},
+		UnreadKeys: []string{},
+	}
+
+	registeredModules := map[string]bool{}
+	for _, reg_mod := range moduleRegistry() {
+		registeredModules[reg_mod.module.Name] = true
+	}
+
+	registeredMiddleware := map[string]bool{}
+	for _, middleware := range GetMiddlewares() {
+		registeredMiddleware[middleware.Name] = true
+	}
+
+	// server block
+	if _, ok := getConfigBlock(conf, "server"); ok {
+		report.Blocks = append(report.Blocks, configBlock("server", "server", true))
+	}
+
+	// module and middleware blocks
+	for _, kind := range []struct {
+		section    string
+		kind       string
+		registered map[string]bool
+	}{
+		{"modules", "module", registeredModules},
+		{"middleware", "middleware", registeredMiddleware},
+	} {
+		section, _ := getConfigBlock(conf, kind.section)
+		for _, name := range sortedKeys(section) {
+			path := kind.section + "." + name
+			report.Blocks = append(report.Blocks, configBlock(path, kind.kind, kind.registered[name]))
+		}
+	}
+
+	// unread keys
+	var leaves []string
+	collectLeaves(map[string]any(conf), "", &leaves)
+	for _, leaf := range leaves {
+		if !isRead(leaf) {
+			report.UnreadKeys = append(report.UnreadKeys, leaf)
+		}
+	}
+
+	return report
+}
+
+// configBlock creates the report entry of a config block.
+func configBlock(path string, kind string, registered bool) karotteapi.ConfigBlock {
+	block := karotteapi.ConfigBlock{
+		Path:       path,
+		Kind:       kind,
+		Registered: registered,
+		ReadKeys:   []string{},
+	}
+
+	trackLock.Lock()
+	defer trackLock.Unlock()
+
+	block.Consumed = consumed_blocks[strings.ToLower(path)]
+
+	for key := range read_keys {
+		if strings.HasPrefix(key, path+".") {
+			block.ReadKeys = append(block.ReadKeys, strings.TrimPrefix(key, path+"."))
+		}
+	}
+	sort.Strings(block.ReadKeys)
+
+	return block
+}
+
+// collectLeaves collects the paths of all non-map values.
+func collectLeaves(m map[string]any, prefix string, leaves *[]string) {
+	for _, key := range sortedKeys(m) {
+		path := key
+		if prefix != "" {
+			path = prefix + "." + key
+		}
+
+		nested, ok := m[key].(map[string]any)
+		if ok {
+			collectLeaves(nested, path, leaves)
+		} else {
+			*leaves = append(*leaves, path)
+		}
+	}
+}
+
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,139 @@
+package internal
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/karotte128/karotteapi"
+)
+
+func TestIsSensitiveKey(t *testing.T) {
+	for _, key := range []string{"password", "DB_PASSWORD", "client-secret", "token", "keys", "api_key", "signing_key", "Authorization",
+		"secrets", "tokens", "passwords", "client_secrets", "signing_keys", "private_keys", "credential", "credentials", "hash", "password_hash"} {
+		if !isSensitiveKey(key) {
+			t.Errorf("%s is not sensitive", key)
+		}
+	}
+	for _, key := range []string{"key", "apikey_header", "header", "tokens_per_second", "monkey", "secrets_dir", "passwordless"} {
+		if isSensitiveKey(key) {
+			t.Errorf("%s is sensitive", key)
+		}
+	}
+}
+
+func TestRedactBuiltinAuthConfig(t *testing.T) {
+	conf := karotteapi.Config{
+		"middleware": map[string]any{
+			"auth": map[string]any{
+				"methods": []any{"bearer", "basic", "hmac", "apikey"},
+				"bearer": map[string]any{
+					"tokens": map[string]any{
+						"bearertoken1": "service-a",
+						"bearertoken2": map[string]any{"id": "service-b", "roles": []any{"admin"}},
+					},
+				},
+				"basic": map[string]any{
+					"users": map[string]any{
+						"alice": "$2a$10$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy",
+						"bob":   map[string]any{"hash": "$2a$10$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy", "roles": []any{"user"}},
+					},
+				},
+				"hmac": map[string]any{
+					"max_skew": int64(300),
+					"secrets":  map[string]any{"k1": "hmacsecret"},
+				},
+				"apikey": map[string]any{
+					"header": "X-API-Key",
+					"keys":   map[string]any{"apikey1": "service-c"},
+				},
+			},
+			"jwt": map[string]any{
+				"issuer": "https://issuer.example",
+				"keys":   map[string]any{"key-1": "jwtsecret"},
+			},
+			"ratelimit": map[string]any{"key": "ip"},
+		},
+	}
+
+	redacted := RedactConfig(conf)
+
+	text := fmt.Sprint(redacted)
+	for _, secret := range []string{"bearertoken", "service-b", "$2a$", "k1", "hmacsecret", "apikey1", "key-1", "jwtsecret"} {
+		if strings.Contains(text, secret) {
+			t.Errorf("%s is not redacted: %s", secret, text)
+		}
+	}
+
+	auth := redacted["middleware"].(map[string]any)["auth"].(map[string]any)
+	for _, path := range [][]string{{"bearer", "tokens"}, {"hmac", "secrets"}} {
+		if got := auth[path[0]].(map[string]any)[path[1]]; got != redactedValue {
+			t.Errorf("%v = %v", path, got)
+		}
+	}
+	// apikey is a sensitive key itself, so the whole block is redacted
+	if auth["apikey"] != redactedValue {
+		t.Errorf("apikey = %v", auth["apikey"])
+	}
+
+	// the other values stay readable
+	users := auth["basic"].(map[string]any)["users"].(map[string]any)
+	if _, ok := users["alice"]; !ok || users["bob"].(map[string]any)["roles"] == nil {
+		t.Errorf("users = %v", users)
+	}
+	if auth["hmac"].(map[string]any)["max_skew"] != int64(300) {
+		t.Errorf("auth = %v", auth)
+	}
+	middleware := redacted["middleware"].(map[string]any)
+	if middleware["ratelimit"].(map[string]any)["key"] != "ip" || middleware["jwt"].(map[string]any)["issuer"] != "https://issuer.example" {
+		t.Errorf("middleware = %v", middleware)
+	}
+}
+
+func TestConfigReportReadKeys(t *testing.T) {
+	err := LoadConfig(karotteapi.Config{
+		"middleware": map[string]any{
+			"contenttype": map[string]any{"enable": true, "default": "text/plain"},
+			"ratelimit": map[string]any{
+				"key":    "ip",
+				"limits": map[string]any{"burst": int64(10), "rate": 1.0},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ratelimit, _ := GetMiddlewareConfig("ratelimit")
+	GetNestedValue[string](ratelimit, "key")
+	// nested maps are tracked as well
+	limits := ratelimit["limits"].(map[string]any)
+	GetNestedValue[int64](limits, "burst")
+
+	contentType, _ := GetMiddlewareConfig("contentType")
+	GetNestedValue[string](contentType, "default")
+
+	report := GetConfigReport()
+
+	if report.Config["middleware"].(map[string]any)["ratelimit"].(map[string]any)["key"] != "ip" {
+		t.Errorf("ratelimit key was redacted: %v", report.Config)
+	}
+
+	for _, block := range report.Blocks {
+		switch block.Path {
+		case "middleware.ratelimit":
+			if !block.Consumed || !slices.Equal(block.ReadKeys, []string{"key", "limits.burst"}) {
+				t.Errorf("ratelimit block = %+v", block)
+			}
+		case "middleware.contenttype":
+			if !block.Consumed || !slices.Equal(block.ReadKeys, []string{"default"}) {
+				t.Errorf("contenttype block = %+v", block)
+			}
+		}
+	}
+
+	if !slices.Contains(report.UnreadKeys, "middleware.ratelimit.limits.rate") || slices.Contains(report.UnreadKeys, "middleware.ratelimit.limits.burst") {
+		t.Errorf("unread keys = %v", report.UnreadKeys)
+	}
+}
@@ -0,0 +1,43 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/karotte128/karotteapi"
+)
+
+func TestGetConfigBlockCase(t *testing.T) {
+	conf := karotteapi.Config{
+		"middleware": map[string]any{
+			// created by KAROTTE_MIDDLEWARE__CONTENTTYPE__DEFAULT without a file entry
+			"contenttype": map[string]any{"default": "text/plain"},
+			"logging":     map[string]any{"enable": true},
+		},
+	}
+
+	block, ok := getConfigBlock(conf, "middleware", "contentType")
+	if !ok || block["default"] != "text/plain" {
+		t.Errorf("contentType block = %v, %v", block, ok)
+	}
+
+	if _, ok := getConfigBlock(conf, "middleware", "cors"); ok {
+		t.Error("found a block that does not exist")
+	}
+	if _, ok := getConfigBlock(conf, "middleware", "logging", "enable"); ok {
+		t.Error("a value was returned as block")
+	}
+}
+
+func TestGetConfigBlockExactMatchWins(t *testing.T) {
+	conf := karotteapi.Config{
+		"modules": map[string]any{
+			"Example": map[string]any{"v": 1},
+			"example": map[string]any{"v": 2},
+		},
+	}
+
+	block, _ := getConfigBlock(conf, "modules", "example")
+	if block["v"] != 2 {
+		t.Errorf("block = %v", block)
+	}
+}
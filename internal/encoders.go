@@ -0,0 +1,444 @@
+package internal
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/vmihailenco/msgpack/v5"
+
+	"github.com/karotte128/karotteapi"
+)
+
+// registeredEncoder is an encoder of the encoder registry.
+type registeredEncoder struct {
+	mediaType string
+	encoder   karotteapi.Encoder
+}
+
+// encoder_registry holds the response encoders in the order of preference.
+// The first encoder is used if the client accepts any media type.
+var encoder_registry = []registeredEncoder{
+	{"application/json", encodeJSON},
+	{"application/xml", encodeXML},
+	{"application/msgpack", encodeMsgpack},
+	{"application/cbor", encodeCBOR},
+	{"text/csv", encodeCSV},
+}
+
+// encoderLock guards encoder_registry.
+var encoderLock sync.RWMutex
+
+// RegisterEncoder adds an encoder for the media type. An encoder registered
+// for the same media type before is replaced.
+func RegisterEncoder(mediaType string, encoder karotteapi.Encoder) {
+	encoderLock.Lock()
+	defer encoderLock.Unlock()
+
+	mediaType = strings.ToLower(mediaType)
+	for i, registered := range encoder_registry {
+		if registered.mediaType == mediaType {
+			encoder_registry[i].encoder = encoder
+			return
+		}
+	}
+	encoder_registry = append(encoder_registry, registeredEncoder{mediaType: mediaType, encoder: encoder})
+}
+
+// WriteResponse writes v with the encoder that matches the Accept header of the request best.
+// If the client accepts none of the media types, a 406 error is written.
+// If the value can not be encoded by the best encoder (e.g. a map as XML), the next accepted encoder is used.
+func WriteResponse(w http.ResponseWriter, r *http.Request, status int, v any) error {
+	w.Header().Add("Vary", "Accept")
+
+	candidates := negotiateEncoders(r.Header.Get("Accept"))
+	if len(candidates) == 0 {
+		httpErr := NewHTTPError(http.StatusNotAcceptable, "not_acceptable", "none of the accepted media types can be produced")
+		httpErr.Extensions = map[string]any{"available": availableMediaTypes()}
+		WriteError(w, r, httpErr)
+		return httpErr
+	}
+
+	var body bytes.Buffer
+	var errs []error
+	for _, candidate := range candidates {
+		body.Reset()
+		err := candidate.encoder(&body, v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", candidate.mediaType, err))
+			continue
+		}
+
+		contentType := candidate.mediaType
+		if strings.HasPrefix(contentType, "text/") {
+			contentType += "; charset=utf-8"
+		}
+
+		header := w.Header()
+		header.Set("Content-Type", contentType)
+		header.Set("Content-Length", strconv.Itoa(body.Len()))
+		w.WriteHeader(status)
+
+		_, err = w.Write(body.Bytes())
+		return err
+	}
+
+	err := fmt.Errorf("failed encoding the response: %v", errs)
+	WriteError(w, r, err)
+	return err
+}
+
+// availableMediaTypes returns the media types of all encoders.
+func availableMediaTypes() []string {
+	encoderLock.RLock()
+	defer encoderLock.RUnlock()
+
+	mediaTypes := make([]string, 0, len(encoder_registry))
+	for _, registered := range encoder_registry {
+		mediaTypes = append(mediaTypes, registered.mediaType)
+	}
+	return mediaTypes
+}
+
+// acceptRange is a media range of the Accept header, e.g. "application/*;q=0.5".
+type acceptRange struct {
+	mediaType string
+	quality   float64
+
+	// specificity is 0 for "*/*", 1 for "type/*" and 2 for "type/subtype".
+	specificity int
+}
+
+// parseAccept parses the media ranges of an Accept header. Invalid ranges are ignored.
+func parseAccept(header string) []acceptRange {
+	var ranges []acceptRange
+	for part := range strings.SplitSeq(header, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		mediaType, params, err := mime.ParseMediaType(part)
+		if err != nil {
+			continue
+		}
+		if mediaType == "*" {
+			mediaType = "*/*"
+		}
+
+		mainType, subType, ok := strings.Cut(mediaType, "/")
+		if !ok {
+			continue
+		}
+
+		quality := 1.0
+		if q, ok := params["q"]; ok {
+			quality, err = strconv.ParseFloat(q, 64)
+			if err != nil || quality < 0 || quality > 1 {
+				continue
+			}
+		}
+
+		specificity := 2
+		if mainType == "*" {
+			specificity = 0
+		} else if subType == "*" {
+			specificity = 1
+		}
+
+		ranges = append(ranges, acceptRange{mediaType: mediaType, quality: quality, specificity: specificity})
+	}
+	return ranges
+}
+
+// matches reports whether the media range contains the media type.
+func (a acceptRange) matches(mediaType string) bool {
+	switch a.specificity {
+	case 0:
+		return true
+	case 1:
+		return strings.HasPrefix(mediaType, strings.TrimSuffix(a.mediaType, "*"))
+	}
+	return a.mediaType == mediaType
+}
+
+// negotiateEncoders returns the encoders accepted by the Accept header, the preferred one first.
+// The quality of a media type is the quality of the most specific range that contains it.
+// Encoders with the same quality keep the order of the registry.
+func negotiateEncoders(accept string) []registeredEncoder {
+	encoderLock.RLock()
+	encoders := slices.Clone(encoder_registry)
+	encoderLock.RUnlock()
+
+	if strings.TrimSpace(accept) == "" {
+		return encoders
+	}
+	ranges := parseAccept(accept)
+
+	type candidate struct {
+		registeredEncoder
+		quality     float64
+		specificity int
+	}
+
+	var candidates []candidate
+	for _, encoder := range encoders {
+		best := acceptRange{specificity: -1}
+		for _, r := range ranges {
+			if r.matches(encoder.mediaType) && r.specificity > best.specificity {
+				best = r
+			}
+		}
+		if best.specificity >= 0 && best.quality > 0 {
+			candidates = append(candidates, candidate{encoder, best.quality, best.specificity})
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].quality != candidates[j].quality {
+			return candidates[i].quality > candidates[j].quality
+		}
+		return candidates[i].specificity > candidates[j].specificity
+	})
+
+	result := make([]registeredEncoder, 0, len(candidates))
+	for _, c := range candidates {
+		result = append(result, c.registeredEncoder)
+	}
+	return result
+}
+
+func encodeJSON(w io.Writer, v any) error {
+	return json.NewEncoder(w).Encode(v)
+}
+
+// xmlList is the root element of lists encoded as XML.
+type xmlList struct {
+	XMLName xml.Name `xml:"items"`
+	Items   any      `xml:"item"`
+}
+
+func encodeXML(w io.Writer, v any) error {
+	// a list has no root element in encoding/xml, so it is wrapped
+	value := reflect.ValueOf(v)
+	if value.Kind() == reflect.Slice || value.Kind() == reflect.Array {
+		v = xmlList{Items: v}
+	}
+
+	_, err := io.WriteString(w, xml.Header)
+	if err != nil {
+		return err
+	}
+	return xml.NewEncoder(w).Encode(v)
+}
+
+func encodeMsgpack(w io.Writer, v any) error {
+	encoder := msgpack.NewEncoder(w)
+	// use the same field names as JSON
+	encoder.SetCustomStructTag("json")
+	return encoder.Encode(v)
+}
+
+func encodeCBOR(w io.Writer, v any) error {
+	// fields without a cbor tag use their json tag
+	return cbor.NewEncoder(w).Encode(v)
+}
+
+// encodeCSV writes a list of structs or maps as table with a header row.
+// A single struct or map is written as a table with one row, other values as a single cell.
+// Nested values are written as JSON. A nil value is written as an empty table.
+func encodeCSV(w io.Writer, v any) error {
+	value := reflect.ValueOf(v)
+	for value.Kind() == reflect.Pointer || value.Kind() == reflect.Interface {
+		value = value.Elem()
+	}
+
+	var rows []reflect.Value
+	if !value.IsValid() {
+		// nil pointer or nil interface, there are no rows
+	} else if value.Kind() == reflect.Slice || value.Kind() == reflect.Array {
+		for i := 0; i < value.Len(); i++ {
+			rows = append(rows, value.Index(i))
+		}
+	} else {
+		rows = []reflect.Value{value}
+	}
+
+	columns := csvColumns(rows)
+	if len(columns) == 0 {
+		return nil
+	}
+
+	writer := csv.NewWriter(w)
+	err := writer.Write(columns)
+	if err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		cells := csvRow(row)
+		record := make([]string, len(columns))
+		for i, column := range columns {
+			record[i] = cells[column]
+		}
+
+		err := writer.Write(record)
+		if err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// csvColumns returns the columns of the rows: the JSON field names of structs in field order,
+// followed by the sorted keys of maps. Rows of other values have the column "value".
+func csvColumns(rows []reflect.Value) []string {
+	var columns []string
+	seen := map[string]bool{}
+	var mapKeys []string
+
+	for _, row := range rows {
+		for row.Kind() == reflect.Pointer || row.Kind() == reflect.Interface {
+			if row.IsNil() {
+				// nil rows have no columns, their cells are empty
+				row = reflect.Value{}
+				break
+			}
+			row = row.Elem()
+		}
+		if !row.IsValid() {
+			continue
+		}
+
+		switch row.Kind() {
+		case reflect.Struct:
+			for _, field := range reflect.VisibleFields(row.Type()) {
+				name, ok := jsonName(field)
+				if ok && field.IsExported() && encodedField(row.Type(), field) && !seen[name] {
+					seen[name] = true
+					columns = append(columns, name)
+				}
+			}
+		case reflect.Map:
+			iter := row.MapRange()
+			for iter.Next() {
+				name := csvText(fmt.Sprint(iter.Key().Interface()))
+				if !seen[name] {
+					seen[name] = true
+					mapKeys = append(mapKeys, name)
+				}
+			}
+		default:
+			if !seen["value"] {
+				seen["value"] = true
+				columns = append(columns, "value")
+			}
+		}
+	}
+
+	sort.Strings(mapKeys)
+	return append(columns, mapKeys...)
+}
+
+// csvRow returns the cells of a row by column.
+func csvRow(row reflect.Value) map[string]string {
+	for row.Kind() == reflect.Pointer || row.Kind() == reflect.Interface {
+		if row.IsNil() {
+			return nil
+		}
+		row = row.Elem()
+	}
+	if !row.IsValid() {
+		return nil
+	}
+
+	cells := map[string]string{}
+	switch row.Kind() {
+	case reflect.Struct:
+		for _, field := range reflect.VisibleFields(row.Type()) {
+			name, ok := jsonName(field)
+			if ok && field.IsExported() && encodedField(row.Type(), field) {
+				// a field of a nil embedded pointer has no value, its cell is empty
+				value, err := row.FieldByIndexErr(field.Index)
+				if err != nil {
+					continue
+				}
+				cells[name] = csvCell(value)
+			}
+		}
+	case reflect.Map:
+		iter := row.MapRange()
+		for iter.Next() {
+			cells[csvText(fmt.Sprint(iter.Key().Interface()))] = csvCell(iter.Value())
+		}
+	default:
+		cells["value"] = csvCell(row)
+	}
+	return cells
+}
+
+// csvCell formats a single value.
+func csvCell(v reflect.Value) string {
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return ""
+		}
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		return ""
+	}
+	if (v.Kind() == reflect.Slice || v.Kind() == reflect.Map) && v.IsNil() {
+		return ""
+	}
+
+	if marshaler, ok := v.Interface().(encoding.TextMarshaler); ok {
+		text, err := marshaler.MarshalText()
+		if err == nil {
+			return csvText(string(text))
+		}
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		return csvText(v.String())
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(v.Uint(), 10)
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'g', -1, v.Type().Bits())
+	}
+
+	nested, err := json.Marshal(v.Interface())
+	if err != nil {
+		return fmt.Sprint(v.Interface())
+	}
+	return string(nested)
+}
+
+// csvText neutralizes text that a spreadsheet would run as formula (CSV injection)
+// by prefixing it with a single quote. Numbers are not text, so negative numbers are kept.
+func csvText(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
@@ -0,0 +1,79 @@
+package internal
+
+import (
+	"bytes"
+	"testing"
+)
+
+type csvOrder struct {
+	ID    int     `json:"id"`
+	Note  string  `json:"note"`
+	Price float64 `json:"price"`
+	Tags  *[]string
+}
+
+func TestEncodeCSV(t *testing.T) {
+	tags := []string{"a"}
+	for _, test := range []struct {
+		name  string
+		value any
+		want  string
+	}{
+		{"list", []csvOrder{{ID: 1, Note: "ok", Price: -2.5, Tags: &tags}, {ID: 2}}, "id,note,price,Tags\n1,ok,-2.5,\"[\"\"a\"\"]\"\n2,,0,\n"},
+		{"single struct", csvOrder{ID: 1}, "id,note,price,Tags\n1,,0,\n"},
+		{"map", map[string]any{"b": nil, "a": 1}, "a,b\n1,\n"},
+		{"scalar", 42, "value\n42\n"},
+		{"nil", nil, ""},
+		{"nil pointer", (*csvOrder)(nil), ""},
+		{"nil slice", []csvOrder(nil), ""},
+		{"nil elements", []*csvOrder{nil, {ID: 3}}, "id,note,price,Tags\n,,,\n3,,0,\n"},
+		{"nil interface elements", []any{nil, 1}, "value\n\n1\n"},
+		{"nil map values", map[string]any{"a": (*int)(nil)}, "a\n\n"},
+	} {
+		var buf bytes.Buffer
+		if err := encodeCSV(&buf, test.value); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if buf.String() != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, buf.String(), test.want)
+		}
+	}
+}
+
+type csvBase struct {
+	ID int `json:"id"`
+}
+
+type csvEmbedding struct {
+	*csvBase
+	Name string `json:"name"`
+}
+
+func TestEncodeCSVNilEmbeddedPointer(t *testing.T) {
+	var buf bytes.Buffer
+	err := encodeCSV(&buf, []csvEmbedding{{Name: "a"}, {csvBase: &csvBase{ID: 2}, Name: "b"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "id,name\n,a\n2,b\n"
+	if buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
+}
+
+func TestEncodeCSVNeutralizesFormulas(t *testing.T) {
+	var buf bytes.Buffer
+	err := encodeCSV(&buf, []map[string]any{
+		{"=cmd": "=1+2", "note": "+SUM(A1)", "user": "@admin", "debt": "-1", "count": -1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "'=cmd,count,debt,note,user\n'=1+2,-1,'-1,'+SUM(A1),'@admin\n"
+	if buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
+}
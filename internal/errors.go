@@ -0,0 +1,163 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"maps"
+	"net/http"
+
+	"github.com/karotte128/karotteapi"
+)
+
+// ContentTypeProblem is the media type of RFC 9457 problem details.
+const ContentTypeProblem = "application/problem+json"
+
+// problemDetails is the JSON body of error responses written by the framework.
+type problemDetails struct {
+	Type      string                  `json:"type"`
+	Title     string                  `json:"title"`
+	Status    int                     `json:"status"`
+	Detail    string                  `json:"detail,omitempty"`
+	Instance  string                  `json:"instance,omitempty"`
+	Code      string                  `json:"code,omitempty"`
+	RequestID string                  `json:"request_id,omitempty"`
+	Errors    []karotteapi.FieldError `json:"errors,omitempty"`
+
+	extensions map[string]any
+}
+
+// MarshalJSON adds the extension members to the problem details.
+// The standard members can not be overwritten by extensions.
+func (p problemDetails) MarshalJSON() ([]byte, error) {
+	type plain problemDetails
+	body, err := json.Marshal(plain(p))
+	if err != nil || len(p.extensions) == 0 {
+		return body, err
+	}
+
+	members := map[string]any{}
+	maps.Copy(members, p.extensions)
+
+	var standard map[string]any
+	err = json.Unmarshal(body, &standard)
+	if err != nil {
+		return nil, err
+	}
+	maps.Copy(members, standard)
+
+	return json.Marshal(members)
+}
+
+// NewHTTPError creates an HTTPError.
+func NewHTTPError(status int, code string, detail string) *karotteapi.HTTPError {
+	return &karotteapi.HTTPError{Status: status, Code: code, Detail: detail}
+}
+
+// WriteError writes the error as RFC 9457 problem details.
+// Errors that are not an HTTPError are logged and written as 500 without details,
+// so internal messages are never sent to the client.
+// The cause of an HTTPError is logged as error for server errors (5xx) and at debug level otherwise.
+func WriteError(w http.ResponseWriter, r *http.Request, err error) {
+	var httpErr *karotteapi.HTTPError
+	if !errors.As(err, &httpErr) {
+		Logger().ErrorContext(r.Context(), "request failed", "method", r.Method, "path", r.URL.Path, "error", err)
+		httpErr = NewHTTPError(http.StatusInternalServerError, "", "an unexpected error occurred")
+	}
+
+	status := httpErr.Status
+	if status < 400 || status > 599 {
+		status = http.StatusInternalServerError
+	}
+
+	if httpErr.Err != nil {
+		level := slog.LevelDebug
+		if status >= 500 {
+			level = slog.LevelError
+		}
+		Logger().Log(r.Context(), level, "request failed", "method", r.Method, "path", r.URL.Path, "status", status, "error", httpErr.Err)
+	}
+
+	problem := problemDetails{
+		Type:       httpErr.Type,
+		Title:      http.StatusText(status),
+		Status:     status,
+		Detail:     httpErr.Detail,
+		Instance:   r.URL.Path,
+		Code:       httpErr.Code,
+		RequestID:  GetRequestID(r.Context()),
+		Errors:     httpErr.Fields,
+		extensions: httpErr.Extensions,
+	}
+	if problem.Type == "" {
+		problem.Type = "about:blank"
+	}
+
+	writeProblem(w, problem)
+}
+
+// writeProblem writes the problem details with their status.
+func writeProblem(w http.ResponseWriter, problem problemDetails) {
+	header := w.Header()
+	header.Del("Content-Length")
+	header.Set("Content-Type", ContentTypeProblem)
+	header.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(problem.Status)
+
+	json.NewEncoder(w).Encode(problem)
+}
+
+// muxErrorRecorder records the status and headers written by the not found and
+// method not allowed handlers of http.ServeMux and discards the plain text body.
+type muxErrorRecorder struct {
+	header http.Header
+	status int
+}
+
+func (m *muxErrorRecorder) Header() http.Header {
+	return m.header
+}
+
+func (m *muxErrorRecorder) WriteHeader(code int) {
+	if m.status == 0 {
+		m.status = code
+	}
+}
+
+func (m *muxErrorRecorder) Write(b []byte) (int, error) {
+	m.WriteHeader(http.StatusOK)
+	return len(b), nil
+}
+
+// HandleMuxErrors returns a handler that serves the requests with the handler,
+// but writes the 404 and 405 responses of a http.ServeMux as problem details.
+// Handlers that are not a http.ServeMux are returned unchanged.
+func HandleMuxErrors(handler http.Handler) http.Handler {
+	mux, ok := handler.(*http.ServeMux)
+	if !ok {
+		return handler
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the mux returns an empty pattern only if no route matches the request
+		fallback, pattern := mux.Handler(r)
+		if pattern != "" {
+			mux.ServeHTTP(w, r)
+			return
+		}
+
+		recorder := &muxErrorRecorder{header: http.Header{}}
+		fallback.ServeHTTP(recorder, r)
+
+		switch recorder.status {
+		case http.StatusNotFound:
+			WriteError(w, r, NewHTTPError(http.StatusNotFound, "not_found", "no route matches the path"))
+		case http.StatusMethodNotAllowed:
+			w.Header().Set("Allow", recorder.header.Get("Allow"))
+			WriteError(w, r, NewHTTPError(http.StatusMethodNotAllowed, "method_not_allowed", "the route does not support the method"))
+		default:
+			// not an error of the mux, serve it as usual
+			mux.ServeHTTP(w, r)
+		}
+	})
+}
@@ -0,0 +1,52 @@
+package internal
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWriteErrorLogsCauseOfServerErrors(t *testing.T) {
+	old := Logger()
+	t.Cleanup(func() {
+		logger.Store(old)
+		customLogHandler.Store(false)
+	})
+
+	var buf bytes.Buffer
+	SetLogHandler(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo}))
+
+	for _, test := range []struct {
+		status int
+		logged bool
+	}{
+		{http.StatusGatewayTimeout, true},
+		{http.StatusInternalServerError, true},
+		// client errors are only logged at debug level
+		{http.StatusNotFound, false},
+	} {
+		buf.Reset()
+
+		httpErr := NewHTTPError(test.status, "", "failed")
+		httpErr.Err = errors.New("database is down")
+
+		w := httptest.NewRecorder()
+		WriteError(w, httptest.NewRequest(http.MethodGet, "/orders", nil), httpErr)
+
+		if w.Code != test.status {
+			t.Errorf("%d: status = %d", test.status, w.Code)
+		}
+		if strings.Contains(w.Body.String(), "database is down") {
+			t.Errorf("%d: the cause was sent to the client", test.status)
+		}
+
+		logged := strings.Contains(buf.String(), "level=ERROR") && strings.Contains(buf.String(), "database is down")
+		if logged != test.logged {
+			t.Errorf("%d: log = %q", test.status, buf.String())
+		}
+	}
+}
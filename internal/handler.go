@@ -0,0 +1,273 @@
+package internal
+
+import (
+	"context"
+	"encoding"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/karotte128/karotteapi"
+)
+
+// paramSources are the struct tags that bind request parameters, in binding order.
+var paramSources = []string{"path", "query", "header"}
+
+// paramBinding binds a request parameter to a field of the request struct.
+type paramBinding struct {
+	index  []int
+	source string
+	name   string
+}
+
+// requestBinding describes how a request is bound to a request struct.
+type requestBinding struct {
+	params []paramBinding
+
+	// hasBody is true if the struct has fields that are read from the JSON body.
+	hasBody bool
+}
+
+// statusCoder is implemented by responses that set their own status code.
+type statusCoder interface {
+	StatusCode() int
+}
+
+var (
+	textUnmarshalerType = reflect.TypeFor[encoding.TextUnmarshaler]()
+	durationType        = reflect.TypeFor[time.Duration]()
+)
+
+// Handle returns a handler that binds the request to Req, validates it, calls fn
+// and writes the response with WriteResponse. Errors are written with WriteError.
+// It panics if Req is not a struct or has parameter fields of an unsupported type.
+func Handle[Req, Resp any](fn func(ctx context.Context, req Req) (Resp, error)) http.Handler {
+	binding, err := newRequestBinding(reflect.TypeFor[Req]())
+	if err != nil {
+		panic(fmt.Sprintf("karotteapi: invalid request type %s: %v", reflect.TypeFor[Req](), err))
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req Req
+		err := binding.bind(w, r, &req)
+		if err != nil {
+			WriteError(w, r, err)
+			return
+		}
+
+		resp, err := fn(r.Context(), req)
+		if err != nil {
+			WriteError(w, r, handlerError(err))
+			return
+		}
+
+		status := http.StatusOK
+		if coder, ok := any(resp).(statusCoder); ok {
+			status = coder.StatusCode()
+		}
+
+		if status == http.StatusNoContent || status == http.StatusNotModified {
+			w.WriteHeader(status)
+			return
+		}
+		WriteResponse(w, r, status, resp)
+	})
+}
+
+// handlerError maps errors of a handler function that are not HTTPErrors to one.
+func handlerError(err error) error {
+	var httpErr *karotteapi.HTTPError
+	switch {
+	case errors.As(err, &httpErr):
+		return err
+	case errors.Is(err, context.DeadlineExceeded):
+		return &karotteapi.HTTPError{Status: http.StatusGatewayTimeout, Code: "timeout", Detail: "the request timed out", Err: err}
+	case errors.Is(err, context.Canceled):
+		return &karotteapi.HTTPError{Status: http.StatusServiceUnavailable, Code: "canceled", Detail: "the request was canceled", Err: err}
+	}
+	return err
+}
+
+// newRequestBinding collects the parameter fields of the struct type t.
+func newRequestBinding(t reflect.Type) (*requestBinding, error) {
+	if t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("must be a struct")
+	}
+
+	binding := &requestBinding{}
+	for _, field := range reflect.VisibleFields(t) {
+		if !field.IsExported() || (field.Anonymous && field.Type.Kind() == reflect.Struct) {
+			continue
+		}
+
+		isParam := false
+		for _, source := range paramSources {
+			name, ok := field.Tag.Lookup(source)
+			if !ok {
+				continue
+			}
+			if !bindable(field.Type) {
+				return nil, fmt.Errorf("field %s: type %s can not be bound from a %s parameter", field.Name, field.Type, source)
+			}
+
+			binding.params = append(binding.params, paramBinding{index: field.Index, source: source, name: name})
+			isParam = true
+		}
+
+		if !isParam && field.Tag.Get("json") != "-" {
+			binding.hasBody = true
+		}
+	}
+	return binding, nil
+}
+
+// bindable reports whether parameters can be converted to the type.
+func bindable(t reflect.Type) bool {
+	if reflect.PointerTo(t).Implements(textUnmarshalerType) {
+		return true
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return bindable(t.Elem())
+	case reflect.Slice:
+		return t.Elem().Kind() != reflect.Slice && bindable(t.Elem())
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// bind reads the JSON body and the parameters of the request into req and validates it.
+// Parameters are bound after the body, so they take precedence.
+func (b *requestBinding) bind(w http.ResponseWriter, r *http.Request, req any) error {
+	if b.hasBody && r.Body != nil && r.Body != http.NoBody && r.ContentLength != 0 {
+		err := decodeJSONBody(w, r, req, DefaultMaxBodySize)
+		if err != nil {
+			return err
+		}
+	}
+
+	v := reflect.ValueOf(req).Elem()
+	var fields []karotteapi.FieldError
+	for _, param := range b.params {
+		values := paramValues(r, param)
+		if len(values) == 0 {
+			continue
+		}
+
+		err := setParam(v.FieldByIndex(param.index), values)
+		if err != nil {
+			fields = append(fields, karotteapi.FieldError{Field: param.name, Message: err.Error()})
+		}
+	}
+
+	if len(fields) > 0 {
+		httpErr := NewHTTPError(http.StatusBadRequest, "invalid_parameter", "the request contains invalid parameters")
+		httpErr.Fields = fields
+		return httpErr
+	}
+
+	return Validate(req)
+}
+
+// paramValues returns the values of a parameter of the request.
+func paramValues(r *http.Request, param paramBinding) []string {
+	switch param.source {
+	case "path":
+		if value := r.PathValue(param.name); value != "" {
+			return []string{value}
+		}
+	case "query":
+		return r.URL.Query()[param.name]
+	case "header":
+		return r.Header.Values(param.name)
+	}
+	return nil
+}
+
+// setParam converts the values to the type of the field. Slices receive all values,
+// other types the first one.
+func setParam(field reflect.Value, values []string) error {
+	if field.Kind() == reflect.Slice && !reflect.PointerTo(field.Type()).Implements(textUnmarshalerType) {
+		slice := reflect.MakeSlice(field.Type(), len(values), len(values))
+		for i, value := range values {
+			err := setValue(slice.Index(i), value)
+			if err != nil {
+				return err
+			}
+		}
+		field.Set(slice)
+		return nil
+	}
+
+	return setValue(field, values[0])
+}
+
+// setValue converts a single value to the type of v.
+func setValue(v reflect.Value, value string) error {
+	if unmarshaler, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		if unmarshaler.UnmarshalText([]byte(value)) != nil {
+			return errors.New("is invalid")
+		}
+		return nil
+	}
+
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return errors.New("must be a duration")
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer:
+		elem := reflect.New(v.Type().Elem())
+		err := setValue(elem.Elem(), value)
+		if err != nil {
+			return err
+		}
+		v.Set(elem)
+
+	case reflect.String:
+		v.SetString(value)
+
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return errors.New("must be a boolean")
+		}
+		v.SetBool(b)
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(value, 10, v.Type().Bits())
+		if err != nil {
+			return errors.New("must be an integer")
+		}
+		v.SetInt(i)
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(value, 10, v.Type().Bits())
+		if err != nil {
+			return errors.New("must be a positive integer")
+		}
+		v.SetUint(u)
+
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(value, v.Type().Bits())
+		if err != nil {
+			return errors.New("must be a number")
+		}
+		v.SetFloat(f)
+	}
+
+	return nil
+}
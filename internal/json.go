@@ -0,0 +1,163 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/karotte128/karotteapi"
+)
+
+// DefaultMaxBodySize is the maximum size of a JSON request body read by DecodeJSON.
+const DefaultMaxBodySize = 1 << 20
+
+// DecodeJSON reads the JSON body of the request into v and validates it with Validate.
+// The body must not be larger than maxBytes, contain unknown fields or more than one value.
+// All errors are HTTPErrors (400, 413, 415 or 422) that can be passed to WriteError,
+// except for invalid validate tags.
+func DecodeJSON(w http.ResponseWriter, r *http.Request, v any, maxBytes int64) error {
+	err := decodeJSONBody(w, r, v, maxBytes)
+	if err != nil {
+		return err
+	}
+	return Validate(v)
+}
+
+// decodeJSONBody reads the JSON body of the request into v without validating it.
+func decodeJSONBody(w http.ResponseWriter, r *http.Request, v any, maxBytes int64) error {
+	contentType := r.Header.Get("Content-Type")
+	if contentType != "" {
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err != nil || (mediaType != "application/json" && !strings.HasSuffix(mediaType, "+json")) {
+			return NewHTTPError(http.StatusUnsupportedMediaType, "unsupported_media_type", "the request body must be application/json")
+		}
+	}
+
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBytes))
+	decoder.DisallowUnknownFields()
+
+	err := decoder.Decode(v)
+	if err != nil {
+		return jsonDecodeError(err, maxBytes)
+	}
+
+	// the body must only contain a single value
+	err = decoder.Decode(&struct{}{})
+	if err != io.EOF {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return jsonDecodeError(err, maxBytes)
+		}
+		return NewHTTPError(http.StatusBadRequest, "invalid_json", "the request body must contain a single JSON value")
+	}
+
+	return nil
+}
+
+// jsonDecodeError converts an error of the JSON decoder to an HTTPError.
+func jsonDecodeError(err error, maxBytes int64) error {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	var maxBytesErr *http.MaxBytesError
+
+	switch {
+	case errors.As(err, &maxBytesErr):
+		return NewHTTPError(http.StatusRequestEntityTooLarge, "body_too_large", fmt.Sprintf("the request body must not be larger than %d bytes", maxBytes))
+
+	case errors.Is(err, io.EOF):
+		return NewHTTPError(http.StatusBadRequest, "invalid_json", "the request body is empty")
+
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return NewHTTPError(http.StatusBadRequest, "invalid_json", "the request body contains incomplete JSON")
+
+	case errors.As(err, &syntaxErr):
+		return NewHTTPError(http.StatusBadRequest, "invalid_json", fmt.Sprintf("the request body contains malformed JSON at offset %d", syntaxErr.Offset))
+
+	case errors.As(err, &typeErr):
+		httpErr := NewHTTPError(http.StatusBadRequest, "invalid_json", "the request body contains fields of the wrong type")
+		field := jsonFieldPath(typeErr.Field)
+		if field == "" {
+			field = "(body)"
+		}
+		httpErr.Fields = []karotteapi.FieldError{{Field: field, Message: "must be " + jsonTypeName(typeErr.Type.Kind())}}
+		return httpErr
+	}
+
+	// the decoder has no typed error for unknown fields
+	if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+		httpErr := NewHTTPError(http.StatusBadRequest, "invalid_json", "the request body contains unknown fields")
+		name, unquoteErr := strconv.Unquote(field)
+		if unquoteErr != nil {
+			name = field
+		}
+		httpErr.Fields = []karotteapi.FieldError{{Field: name, Message: "is not allowed"}}
+		return httpErr
+	}
+
+	return &karotteapi.HTTPError{Status: http.StatusBadRequest, Code: "invalid_json", Detail: "the request body could not be decoded", Err: err}
+}
+
+// jsonFieldPath converts a field path of the decoder ("items.0.count") to the
+// notation used by Validate ("items[0].count").
+func jsonFieldPath(path string) string {
+	var result strings.Builder
+	for i, part := range strings.Split(path, ".") {
+		if _, err := strconv.Atoi(part); err == nil && i > 0 {
+			result.WriteString("[" + part + "]")
+			continue
+		}
+		if i > 0 {
+			result.WriteString(".")
+		}
+		result.WriteString(part)
+	}
+	return result.String()
+}
+
+// jsonTypeName returns the JSON name of a Go kind, e.g. "a number" for int64.
+func jsonTypeName(kind reflect.Kind) string {
+	switch kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	}
+	return "an object"
+}
+
+// WriteJSON writes v as JSON response with the status.
+// The value is encoded before anything is written, so an encoding error results in a 500 response.
+func WriteJSON(w http.ResponseWriter, status int, v any) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		Logger().Error("failed encoding the response", "error", err)
+		writeProblem(w, problemDetails{
+			Type:   "about:blank",
+			Title:  http.StatusText(http.StatusInternalServerError),
+			Status: http.StatusInternalServerError,
+			Detail: "the response could not be encoded",
+		})
+		return err
+	}
+	body = append(body, '\n')
+
+	header := w.Header()
+	header.Set("Content-Type", "application/json")
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(status)
+
+	_, err = w.Write(body)
+	return err
+}
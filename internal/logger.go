@@ -0,0 +1,123 @@
+package internal
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/karotte128/karotteapi"
+)
+
+// logLevel is the level of the builtin log handler. It is read from [server] log_level
+// and can be changed on config reload.
+var logLevel = new(slog.LevelVar)
+
+// customLogHandler is true if the host application set its own handler.
+var customLogHandler atomic.Bool
+
+// logger is the logger of the framework.
+var logger atomic.Pointer[slog.Logger]
+
+func init() {
+	logger.Store(slog.New(&contextHandler{slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: logLevel})}))
+}
+
+// contextHandler adds the request id and the trace of the context to every record.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h *contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if ctx != nil {
+		id := GetRequestID(ctx)
+		if id != "" {
+			record.AddAttrs(slog.String("request_id", id))
+		}
+
+		sc := trace.SpanContextFromContext(ctx)
+		if sc.IsValid() {
+			record.AddAttrs(slog.String("trace_id", sc.TraceID().String()), slog.String("span_id", sc.SpanID().String()))
+		}
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h *contextHandler) WithGroup(name string) slog.Handler {
+	return &contextHandler{h.Handler.WithGroup(name)}
+}
+
+// SetLogHandler replaces the log handler of the framework.
+// The [server] log_level and log_format values are ignored afterwards.
+// Loggers retrieved before keep the old handler, so it should be set before the server starts.
+func SetLogHandler(handler slog.Handler) {
+	customLogHandler.Store(true)
+	logger.Store(slog.New(&contextHandler{handler}))
+}
+
+// Logger returns the logger of the framework.
+func Logger() *slog.Logger {
+	return logger.Load()
+}
+
+// ModuleLogger returns a child logger that adds the module name to every record.
+func ModuleLogger(moduleName string) *slog.Logger {
+	return Logger().With("module", moduleName)
+}
+
+// MiddlewareLogger returns a child logger that adds the middleware name to every record.
+func MiddlewareLogger(middlewareName string) *slog.Logger {
+	return Logger().With("middleware", middlewareName)
+}
+
+// ConfigureLogger sets up the builtin log handler from the server config.
+//
+// [server]
+// log_level = "info"    # debug, info, warn or error
+// log_format = "text"   # text or json
+func ConfigureLogger(serverConfig karotteapi.Config) {
+	setLogLevel(serverConfig)
+
+	if customLogHandler.Load() {
+		return
+	}
+
+	options := &slog.HandlerOptions{Level: logLevel}
+
+	format, ok := GetNestedValue[string](serverConfig, "log_format")
+	if !ok {
+		format = "text"
+	}
+
+	switch format {
+	case "text":
+		logger.Store(slog.New(&contextHandler{slog.NewTextHandler(os.Stderr, options)}))
+	case "json":
+		logger.Store(slog.New(&contextHandler{slog.NewJSONHandler(os.Stderr, options)}))
+	default:
+		Logger().Warn("unknown log format, using text", "log_format", format)
+	}
+}
+
+// setLogLevel sets the level of the builtin log handler from the server config.
+func setLogLevel(serverConfig karotteapi.Config) {
+	level, ok := GetNestedValue[string](serverConfig, "log_level")
+	if !ok {
+		logLevel.Set(slog.LevelInfo)
+		return
+	}
+
+	var parsed slog.Level
+	err := parsed.UnmarshalText([]byte(level))
+	if err != nil {
+		Logger().Warn("unknown log level, using info", "log_level", level)
+		parsed = slog.LevelInfo
+	}
+	logLevel.Set(parsed)
+}
@@ -0,0 +1,100 @@
+package internal
+
+import (
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/karotte128/karotteapi/metrics"
+)
+
+// metrics_registry holds the metrics of the framework and of all modules.
+var metrics_registry = metrics.NewRegistry()
+
+// statusNames are the names of the module statuses in the module status metric.
+var statusNames = map[status]string{
+	statusRegistered: "registered",
+	statusRunning:    "running",
+	statusDisabled:   "disabled",
+	statusFailed:     "failed",
+}
+
+// Metrics returns the metrics registry of the framework.
+func Metrics() *metrics.Registry {
+	return metrics_registry
+}
+
+func init() {
+	registerModuleMetrics()
+	registerRuntimeMetrics()
+}
+
+// registerModuleMetrics adds a gauge with the status of every registered module.
+// For each module, the gauge of the current status is 1 and all others are 0.
+func registerModuleMetrics() {
+	moduleStatus := metrics_registry.Gauge("karotteapi_module_status", "Status of the registered modules.", "module", "status")
+
+	metrics_registry.OnCollect(func() {
+		for _, reg_mod := range moduleRegistry() {
+			for s, name := range statusNames {
+				value := 0.0
+				if reg_mod.status == s {
+					value = 1
+				}
+				moduleStatus.Set(value, reg_mod.module.Name, name)
+			}
+		}
+	})
+}
+
+// registerRuntimeMetrics adds metrics of the Go runtime.
+func registerRuntimeMetrics() {
+	// the memory stats are read once per collect, reading them stops the world
+	var memLock sync.Mutex
+	var mem runtime.MemStats
+
+	metrics_registry.OnCollect(func() {
+		memLock.Lock()
+		defer memLock.Unlock()
+		runtime.ReadMemStats(&mem)
+	})
+
+	memStat := func(read func(m *runtime.MemStats) float64) func() float64 {
+		return func() float64 {
+			memLock.Lock()
+			defer memLock.Unlock()
+			return read(&mem)
+		}
+	}
+
+	metrics_registry.GaugeFunc("go_goroutines", "Number of goroutines that currently exist.", func() float64 {
+		return float64(runtime.NumGoroutine())
+	})
+	metrics_registry.GaugeFunc("go_memstats_alloc_bytes", "Number of bytes allocated and still in use.", memStat(func(m *runtime.MemStats) float64 {
+		return float64(m.Alloc)
+	}))
+	metrics_registry.GaugeFunc("go_memstats_sys_bytes", "Number of bytes obtained from the system.", memStat(func(m *runtime.MemStats) float64 {
+		return float64(m.Sys)
+	}))
+	metrics_registry.GaugeFunc("go_memstats_heap_objects", "Number of allocated objects.", memStat(func(m *runtime.MemStats) float64 {
+		return float64(m.HeapObjects)
+	}))
+	metrics_registry.CounterFunc("go_gc_cycles_total", "Number of completed GC cycles.", memStat(func(m *runtime.MemStats) float64 {
+		return float64(m.NumGC)
+	}))
+	metrics_registry.CounterFunc("go_gc_pause_seconds_total", "Total time spent in GC stop-the-world pauses.", memStat(func(m *runtime.MemStats) float64 {
+		return float64(m.PauseTotalNs) / float64(time.Second)
+	}))
+
+	version := "unknown"
+	if info, ok := debug.ReadBuildInfo(); ok {
+		version = info.GoVersion
+	}
+	metrics_registry.Gauge("go_info", "Information about the Go environment.", "version").Set(1, version)
+
+	start := float64(time.Now().UnixNano()) / float64(time.Second)
+	metrics_registry.GaugeFunc("process_start_time_seconds", "Start time of the process since unix epoch in seconds.", func() float64 {
+		return start
+	})
+}
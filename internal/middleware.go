@@ -1,12 +1,10 @@
 package internal
 
 import (
-	"log"
 	"net/http"
+	"slices"
 	"sort"
 
-	cfg "github.com/karotte128/karottelib/config"
-
 	"github.com/karotte128/karotteapi"
 )
 
@@ -25,25 +23,45 @@ import (
 // Middlewares are applied in the same order they were added.
 var middleware_registry []karotteapi.Middleware
 
+// applied_middleware stores all middleware that were applied to the handler.
+var applied_middleware []karotteapi.Middleware
+
 // RegisterMiddleware registers a new global middleware.
 // Usually called from init() inside a middleware package.
 func RegisterMiddleware(middleware karotteapi.Middleware) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+
 	middleware_registry = append(middleware_registry, middleware)
 }
 
 // Middlewares returns all registered middleware.
 func GetMiddlewares() []karotteapi.Middleware {
-	return middleware_registry
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+
+	return slices.Clone(middleware_registry)
+}
+
+// appliedMiddleware returns a copy of the applied middleware.
+func appliedMiddleware() []karotteapi.Middleware {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+
+	return slices.Clone(applied_middleware)
 }
 
 // ApplyRegisteredMiddleware wraps the given handler with all registered
 // middleware functions in registration order.
 func ApplyRegisteredMiddleware(h http.Handler) http.Handler {
-	sort.Slice(middleware_registry, func(i, j int) bool {
+	// middleware with the same priority are applied in registration order
+	registryLock.Lock()
+	sort.SliceStable(middleware_registry, func(i, j int) bool {
 		return middleware_registry[i].Priority < middleware_registry[j].Priority
 	})
+	registryLock.Unlock()
 
-	for _, middleware := range middleware_registry {
+	for _, middleware := range GetMiddlewares() {
 		var enabled bool = false
 
 		if middleware.ForceEnable {
@@ -52,25 +70,28 @@ func ApplyRegisteredMiddleware(h http.Handler) http.Handler {
 			// get enable value from config
 			config, okConfig := GetMiddlewareConfig(middleware.Name)
 			if okConfig {
-				enable_conf, okEnable := cfg.GetNestedValue[bool](config, "enable")
+				enable_conf, okEnable := GetNestedValue[bool](config, "enable")
 				if okEnable {
 					enabled = enable_conf
 				} else {
 					// The config has no enable value.
-					log.Printf("[MIDDLEWARE] %s has no enable value in config!", middleware.Name)
+					MiddlewareLogger(middleware.Name).Warn("middleware has no enable value in config")
 				}
 			} else {
 				// The module has no config entry
-				log.Printf("[MIDDLEWARE] %s has no config!", middleware.Name)
+				MiddlewareLogger(middleware.Name).Warn("middleware has no config")
 			}
 		}
 
 		if enabled {
 			h = middleware.Handler(h)
-			log.Printf("[MIDDLEWARE] %s was applied!", middleware.Name)
+			registryLock.Lock()
+			applied_middleware = append(applied_middleware, middleware)
+			registryLock.Unlock()
+			MiddlewareLogger(middleware.Name).Info("middleware applied")
 		} else {
 			// Middleware is disabled
-			log.Printf("[MIDDLEWARE] %s is disabled.", middleware.Name)
+			MiddlewareLogger(middleware.Name).Info("middleware disabled")
 		}
 	}
 	return h
@@ -1,10 +1,15 @@
 package internal
 
 import (
-	"log"
+	"context"
+	"fmt"
 	"net/http"
+	"slices"
+	"strings"
+	"sync"
 
-	cfg "github.com/karotte128/karottelib/config"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 
 	"github.com/karotte128/karotteapi"
 )
@@ -45,6 +50,22 @@ type registryModule struct {
 // registry holds all globally registered modules.
 var module_registry []registryModule
 
+// module_prefixes maps the mounted prefix of every running module to the module name.
+var module_prefixes = map[string]string{}
+
+// registryLock guards module_registry, module_prefixes, middleware_registry and applied_middleware.
+// They are filled on startup, but read by config reloads (SIGHUP) and requests while the server runs.
+// The lock is never held while module or middleware code is executed.
+var registryLock sync.RWMutex
+
+// moduleRegistry returns a copy of the module registry.
+func moduleRegistry() []registryModule {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+
+	return slices.Clone(module_registry)
+}
+
 // RegisterModule adds a module to the global registry.
 // Typically called from an init() function inside each module package.
 func RegisterModule(module karotteapi.Module) {
@@ -55,30 +76,35 @@ func RegisterModule(module karotteapi.Module) {
 	}
 
 	// add module to registry
+	registryLock.Lock()
 	module_registry = append(module_registry, reg_mod)
+	registryLock.Unlock()
 }
 
 // LoadRegisteredModules loads and starts all modules that registered themselves via init()
 func LoadRegisteredModules(mux *http.ServeMux) {
+	// the startup of every module is a child span, so slow modules are visible
+	ctx, span := StartSpan(context.Background(), "modules.startup")
+	defer span.End()
 
 	// Register and start all modules in the module_registry
-	for i, reg_mod := range module_registry {
+	for i, reg_mod := range moduleRegistry() {
 		var modStatus status
 		var enabled bool = false
 
 		// get enable value from config
 		config, okConfig := GetModuleConfig(reg_mod.module.Name)
 		if okConfig {
-			enable_conf, okEnable := cfg.GetNestedValue[bool](config, "enable")
+			enable_conf, okEnable := GetNestedValue[bool](config, "enable")
 			if okEnable {
 				enabled = enable_conf
 			} else {
 				// The config has no enable value.
-				log.Printf("[MODULE] %s has no enable value in config!", reg_mod.module.Name)
+				ModuleLogger(reg_mod.module.Name).Warn("module has no enable value in config")
 			}
 		} else {
 			// The module has no config entry
-			log.Printf("[MODULE] %s has no config!", reg_mod.module.Name)
+			ModuleLogger(reg_mod.module.Name).Warn("module has no config")
 		}
 
 		// Test if module is enabled
@@ -86,68 +112,121 @@ func LoadRegisteredModules(mux *http.ServeMux) {
 			// Module is running
 			if reg_mod.module.Routes != nil {
 				// Try to start module
-				ok := safeStartModule(reg_mod.module)
+				ok := safeStartModule(ctx, reg_mod.module)
 
 				if ok {
 					// Module successfully started, registering now.
 
 					// Mount each module under its prefix.
 					prefix, handler := reg_mod.module.Routes()
-					mux.Handle(prefix, handler)
+					mux.Handle(prefix, HandleMuxErrors(handler))
+					registryLock.Lock()
+					module_prefixes[prefix] = reg_mod.module.Name
+					registryLock.Unlock()
 
 					// Set module status to running
 					modStatus = statusRunning
 				} else {
 					// Module failed startup!
-					log.Printf("[MODULE] %s was not registered!", reg_mod.module.Name)
+					ModuleLogger(reg_mod.module.Name).Error("module was not registered")
 
 					// Set module status to failed
 					modStatus = statusFailed
 				}
 			} else {
-				log.Printf("[MODULE] %s has no routes!", reg_mod.module.Name)
+				ModuleLogger(reg_mod.module.Name).Error("module has no routes")
 
 				// Set module status to failed
 				modStatus = statusFailed
 			}
 		} else {
 			// Module is disabled
-			log.Printf("[MODULE] %s is disabled.", reg_mod.module.Name)
+			ModuleLogger(reg_mod.module.Name).Info("module disabled")
 
 			// Set module status to disabled
 			modStatus = statusDisabled
 		}
 
+		registryLock.Lock()
 		module_registry[i].status = modStatus
+		registryLock.Unlock()
+	}
+}
+
+// GetModuleForPath returns the name of the running module that serves the path.
+func GetModuleForPath(path string) (string, bool) {
+	name, _, ok := GetModuleMountForPath(path)
+	return name, ok
+}
+
+// GetModuleMountForPath returns the name and the mounted prefix of the running module that serves the path.
+// It follows the rules of http.ServeMux: a prefix ending in "/" matches all paths below it,
+// any other prefix only matches itself. The longest matching prefix wins.
+func GetModuleMountForPath(path string) (string, string, bool) {
+	var name string
+	var mount string
+	var length int = -1
+
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+
+	for prefix, moduleName := range module_prefixes {
+		// ignore the method and host of the pattern (e.g. "GET /example/")
+		_, pattern, found := strings.Cut(prefix, " ")
+		if !found {
+			pattern = prefix
+		}
+		if i := strings.Index(pattern, "/"); i > 0 {
+			pattern = pattern[i:]
+		}
+
+		matches := path == pattern || (strings.HasSuffix(pattern, "/") && strings.HasPrefix(path, pattern))
+		if matches && len(pattern) > length {
+			name = moduleName
+			mount = pattern
+			length = len(pattern)
+		}
 	}
+
+	return name, mount, length >= 0
 }
 
 // ShutdownRegisteredModules shuts down all modules that are running.
 func ShutdownRegisteredModules() {
-	for _, reg_mod := range module_registry {
+	ctx, span := StartSpan(context.Background(), "modules.shutdown")
+	defer span.End()
+
+	for _, reg_mod := range moduleRegistry() {
 		if reg_mod.status == statusRunning {
-			safeShutdownModule(reg_mod.module)
+			safeShutdownModule(ctx, reg_mod.module)
 		}
 	}
 }
 
 // safeShutdownModule is a function that attempts to execute the shutdown function of a module.
 // It makes sure that a panic in the shutdown function does not crash the server.
-func safeShutdownModule(module karotteapi.Module) {
+func safeShutdownModule(ctx context.Context, module karotteapi.Module) {
 	// only execute if the module implements a shutdown function
 	if module.Shutdown != nil {
+		_, span := StartSpan(ctx, "module.shutdown "+module.Name)
+		span.SetAttributes(attribute.String("karotteapi.module", module.Name))
+		defer span.End()
+
 		// recover from panic
 		defer func() {
 			r := recover()
 			if r != nil {
-				log.Printf("[MODULE] %s panicked during shutdown: %v", module.Name, r)
+				ModuleLogger(module.Name).Error("module panicked during shutdown", "panic", r)
+				span.SetStatus(codes.Error, fmt.Sprint("panic: ", r))
 			}
 		}()
 
 		// try to shutdown the module
 		err := module.Shutdown()
 		if err != nil {
-			log.Printf("[MODULE] %s failed shutdown: %v", module.Name, err)
+			ModuleLogger(module.Name).Error("module failed shutdown", "error", err)
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
 		}
 	}
 }
@@ -155,16 +234,21 @@ func safeShutdownModule(module karotteapi.Module) {
 // safeStartModule is a function that attempts to execute the startup function of a module.
 // It returns true if the startup is successfull or the module does not provide a startup function.
 // It makes sure that a panic in the startup function does not crash the server.
-func safeStartModule(module karotteapi.Module) bool {
+func safeStartModule(ctx context.Context, module karotteapi.Module) bool {
 	var ok bool = true
 
 	// only execute if the module implements a startup function
 	if module.Startup != nil {
+		_, span := StartSpan(ctx, "module.startup "+module.Name)
+		span.SetAttributes(attribute.String("karotteapi.module", module.Name))
+		defer span.End()
+
 		// recover from panic
 		defer func() {
 			r := recover()
 			if r != nil {
-				log.Printf("[MODULE] %s panicked during startup: %v", module.Name, r)
+				ModuleLogger(module.Name).Error("module panicked during startup", "panic", r)
+				span.SetStatus(codes.Error, fmt.Sprint("panic: ", r))
 				ok = false
 			}
 		}()
@@ -172,7 +256,9 @@ func safeStartModule(module karotteapi.Module) bool {
 		// try to start the module
 		err := module.Startup()
 		if err != nil {
-			log.Printf("[MODULE] %s failed startup: %v", module.Name, err)
+			ModuleLogger(module.Name).Error("module failed startup", "error", err)
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
 			ok = false
 		}
 
@@ -199,7 +285,7 @@ func GetModuleStatus() ModuleStatus {
 	var disabled int
 	var failed int
 
-	for _, module := range module_registry {
+	for _, module := range moduleRegistry() {
 
 		total++
 
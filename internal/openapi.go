@@ -0,0 +1,631 @@
+package internal
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/karotte128/karotteapi"
+)
+
+// openAPIDocument is an OpenAPI 3.1 document.
+type openAPIDocument struct {
+	OpenAPI    string                      `json:"openapi"`
+	Info       openAPIInfo                 `json:"info"`
+	Servers    []openAPIServer             `json:"servers,omitempty"`
+	Paths      map[string]*openAPIPathItem `json:"paths"`
+	Components openAPIComponents           `json:"components"`
+}
+
+type openAPIInfo struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+type openAPIServer struct {
+	URL string `json:"url"`
+}
+
+// openAPIPathItem contains the operations of a path, in the order of the specification.
+type openAPIPathItem struct {
+	Get     *openAPIOperation `json:"get,omitempty"`
+	Put     *openAPIOperation `json:"put,omitempty"`
+	Post    *openAPIOperation `json:"post,omitempty"`
+	Delete  *openAPIOperation `json:"delete,omitempty"`
+	Options *openAPIOperation `json:"options,omitempty"`
+	Head    *openAPIOperation `json:"head,omitempty"`
+	Patch   *openAPIOperation `json:"patch,omitempty"`
+	Trace   *openAPIOperation `json:"trace,omitempty"`
+}
+
+type openAPIOperation struct {
+	OperationID string                     `json:"operationId,omitempty"`
+	Summary     string                     `json:"summary,omitempty"`
+	Description string                     `json:"description,omitempty"`
+	Tags        []string                   `json:"tags,omitempty"`
+	Deprecated  bool                       `json:"deprecated,omitempty"`
+	Parameters  []openAPIParameter         `json:"parameters,omitempty"`
+	RequestBody *openAPIRequestBody        `json:"requestBody,omitempty"`
+	Responses   map[string]openAPIResponse `json:"responses"`
+	Permissions []string                   `json:"x-permissions,omitempty"`
+}
+
+type openAPIParameter struct {
+	Name     string      `json:"name"`
+	In       string      `json:"in"`
+	Required bool        `json:"required,omitempty"`
+	Schema   *jsonSchema `json:"schema"`
+}
+
+type openAPIRequestBody struct {
+	Required bool                        `json:"required"`
+	Content  map[string]openAPIMediaType `json:"content"`
+}
+
+type openAPIMediaType struct {
+	Schema *jsonSchema `json:"schema,omitempty"`
+}
+
+type openAPIResponse struct {
+	Description string                      `json:"description"`
+	Content     map[string]openAPIMediaType `json:"content,omitempty"`
+}
+
+type openAPIComponents struct {
+	Schemas map[string]*jsonSchema `json:"schemas"`
+}
+
+// jsonSchema is the subset of JSON Schema 2020-12 used by the generated document.
+type jsonSchema struct {
+	Ref                  string                 `json:"$ref,omitempty"`
+	Type                 string                 `json:"type,omitempty"`
+	Format               string                 `json:"format,omitempty"`
+	ContentEncoding      string                 `json:"contentEncoding,omitempty"`
+	Properties           map[string]*jsonSchema `json:"properties,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	Items                *jsonSchema            `json:"items,omitempty"`
+	AdditionalProperties *jsonSchema            `json:"additionalProperties,omitempty"`
+	Enum                 []any                  `json:"enum,omitempty"`
+	Pattern              string                 `json:"pattern,omitempty"`
+	MinLength            *float64               `json:"minLength,omitempty"`
+	MaxLength            *float64               `json:"maxLength,omitempty"`
+	MinItems             *float64               `json:"minItems,omitempty"`
+	MaxItems             *float64               `json:"maxItems,omitempty"`
+	MinProperties        *float64               `json:"minProperties,omitempty"`
+	MaxProperties        *float64               `json:"maxProperties,omitempty"`
+	Minimum              *float64               `json:"minimum,omitempty"`
+	Maximum              *float64               `json:"maximum,omitempty"`
+}
+
+// problemSchemaName is the name of the schema of problem details in the components.
+const problemSchemaName = "Problem"
+
+var (
+	timeType          = reflect.TypeFor[time.Time]()
+	textMarshalerType = reflect.TypeFor[encoding.TextMarshaler]()
+	jsonMarshalerType = reflect.TypeFor[json.Marshaler]()
+
+	// pathParamPattern matches the wildcards of http.ServeMux patterns, e.g. "{id}" or "{path...}".
+	pathParamPattern = regexp.MustCompile(`\{([^}.]*)(\.\.\.)?\}`)
+
+	// schemaNamePattern matches the characters that are not allowed in component names.
+	schemaNamePattern = regexp.MustCompile(`[^a-zA-Z0-9._-]`)
+)
+
+// OpenAPI generates the OpenAPI 3.1 document of the running modules as JSON.
+// If the modules are not loaded yet (e.g. in CI), it contains all modules enabled in the config.
+// The title, version, description and servers are read from [server.openapi].
+func OpenAPI() ([]byte, error) {
+	serverConfig, _ := GetServerConfig()
+	conf, _ := getConfigBlock(serverConfig, "openapi")
+
+	doc := openAPIDocument{
+		OpenAPI: "3.1.0",
+		Info:    openAPIInfo{Title: "KarotteAPI", Version: "1.0.0"},
+		Paths:   map[string]*openAPIPathItem{},
+	}
+	if title, ok := GetNestedValue[string](conf, "title"); ok {
+		doc.Info.Title = title
+	}
+	if version, ok := GetNestedValue[string](conf, "version"); ok {
+		doc.Info.Version = version
+	}
+	doc.Info.Description, _ = GetNestedValue[string](conf, "description")
+
+	servers, _ := GetNestedValue[[]string](conf, "servers")
+	for _, server := range servers {
+		doc.Servers = append(doc.Servers, openAPIServer{URL: server})
+	}
+
+	generator := newSchemaGenerator()
+	for _, module := range documentedModules() {
+		if module.Operations == nil {
+			continue
+		}
+
+		for _, op := range module.Operations() {
+			err := addOperation(&doc, generator, module.Name, op)
+			if err != nil {
+				return nil, fmt.Errorf("module %s: %s %s: %w", module.Name, op.Method, op.Path, err)
+			}
+		}
+	}
+
+	generator.schemas[problemSchemaName] = problemSchema()
+	doc.Components.Schemas = generator.schemas
+
+	return json.MarshalIndent(doc, "", "  ")
+}
+
+// documentedModules returns the running modules, or all modules enabled in the config
+// if the modules are not loaded yet.
+func documentedModules() []karotteapi.Module {
+	registered := moduleRegistry()
+
+	loaded := false
+	for _, reg_mod := range registered {
+		if reg_mod.status != statusRegistered {
+			loaded = true
+			break
+		}
+	}
+
+	var modules []karotteapi.Module
+	for _, reg_mod := range registered {
+		if loaded {
+			if reg_mod.status == statusRunning {
+				modules = append(modules, reg_mod.module)
+			}
+			continue
+		}
+
+		config, _ := GetModuleConfig(reg_mod.module.Name)
+		if enabled, _ := GetNestedValue[bool](config, "enable"); enabled {
+			modules = append(modules, reg_mod.module)
+		}
+	}
+	return modules
+}
+
+// addOperation adds an operation of a module to the document.
+func addOperation(doc *openAPIDocument, generator *schemaGenerator, moduleName string, op karotteapi.Operation) error {
+	method := strings.ToUpper(op.Method)
+	path, pathParams := openAPIPath(op.Path)
+
+	item, ok := doc.Paths[path]
+	if !ok {
+		item = &openAPIPathItem{}
+		doc.Paths[path] = item
+	}
+
+	slot := item.operation(method)
+	if slot == nil {
+		return fmt.Errorf("unsupported method")
+	}
+	if *slot != nil {
+		return fmt.Errorf("duplicate operation")
+	}
+
+	operation := &openAPIOperation{
+		OperationID: op.OperationID,
+		Summary:     op.Summary,
+		Description: op.Description,
+		Tags:        op.Tags,
+		Deprecated:  op.Deprecated,
+		Responses:   map[string]openAPIResponse{},
+		Permissions: op.Permissions,
+	}
+	if len(operation.Tags) == 0 {
+		operation.Tags = []string{moduleName}
+	}
+
+	err := addRequest(operation, generator, method, pathParams, op.Request)
+	if err != nil {
+		return err
+	}
+	addResponses(operation, generator, op)
+
+	*slot = operation
+	return nil
+}
+
+// openAPIPath converts a http.ServeMux pattern path to an OpenAPI path and returns its parameters.
+func openAPIPath(path string) (string, []string) {
+	path = strings.ReplaceAll(path, "{$}", "")
+
+	var params []string
+	path = pathParamPattern.ReplaceAllStringFunc(path, func(match string) string {
+		name := pathParamPattern.FindStringSubmatch(match)[1]
+		params = append(params, name)
+		return "{" + name + "}"
+	})
+	return path, params
+}
+
+// operation returns the field of the path item for the method, or nil if the method is not supported.
+func (item *openAPIPathItem) operation(method string) **openAPIOperation {
+	switch method {
+	case http.MethodGet:
+		return &item.Get
+	case http.MethodPut:
+		return &item.Put
+	case http.MethodPost:
+		return &item.Post
+	case http.MethodDelete:
+		return &item.Delete
+	case http.MethodOptions:
+		return &item.Options
+	case http.MethodHead:
+		return &item.Head
+	case http.MethodPatch:
+		return &item.Patch
+	case http.MethodTrace:
+		return &item.Trace
+	}
+	return nil
+}
+
+// addRequest documents the parameters and the body of the request type.
+// Path parameters of the path that are not bound by the request type are documented as strings.
+func addRequest(operation *openAPIOperation, generator *schemaGenerator, method string, pathParams []string, request any) error {
+	bound := map[string]bool{}
+
+	if request != nil {
+		t := reflect.TypeOf(request)
+		for t.Kind() == reflect.Pointer {
+			t = t.Elem()
+		}
+
+		hasBody := true
+		if t.Kind() == reflect.Struct {
+			binding, err := newRequestBinding(t)
+			if err != nil {
+				return err
+			}
+			hasBody = binding.hasBody
+
+			for _, param := range binding.params {
+				field := t.FieldByIndex(param.index)
+				rules, _ := parseValidateTag(field.Tag.Get("validate"))
+
+				schema := generator.paramSchema(field.Type)
+				applyRules(schema, field.Type, rules)
+
+				operation.Parameters = append(operation.Parameters, openAPIParameter{
+					Name:     param.name,
+					In:       param.source,
+					Required: param.source == "path" || hasRule(rules, "required"),
+					Schema:   schema,
+				})
+				if param.source == "path" {
+					bound[param.name] = true
+				}
+			}
+		}
+
+		// bodies of GET, HEAD and DELETE requests have no defined meaning
+		if hasBody && method != http.MethodGet && method != http.MethodHead && method != http.MethodDelete {
+			operation.RequestBody = &openAPIRequestBody{
+				Required: true,
+				Content: map[string]openAPIMediaType{
+					"application/json": {Schema: generator.schema(t)},
+				},
+			}
+		}
+	}
+
+	for _, name := range pathParams {
+		if !bound[name] {
+			operation.Parameters = append(operation.Parameters, openAPIParameter{
+				Name:     name,
+				In:       "path",
+				Required: true,
+				Schema:   &jsonSchema{Type: "string"},
+			})
+		}
+	}
+	return nil
+}
+
+// addResponses documents the successful response and the problem details of errors.
+func addResponses(operation *openAPIOperation, generator *schemaGenerator, op karotteapi.Operation) {
+	status := op.Status
+	if status == 0 {
+		status = http.StatusOK
+		if op.Response == nil {
+			status = http.StatusNoContent
+		} else if coder, ok := op.Response.(statusCoder); ok {
+			status = coder.StatusCode()
+		}
+	}
+
+	response := openAPIResponse{Description: http.StatusText(status)}
+	if op.Response != nil && status != http.StatusNoContent && status != http.StatusNotModified {
+		mediaType := op.ResponseType
+		if mediaType == "" {
+			mediaType = "application/json"
+		}
+		response.Content = map[string]openAPIMediaType{
+			mediaType: {Schema: generator.schema(reflect.TypeOf(op.Response))},
+		}
+	}
+	operation.Responses[strconv.Itoa(status)] = response
+
+	if len(op.Permissions) > 0 {
+		operation.Responses["401"] = problemResponse(http.StatusUnauthorized)
+		operation.Responses["403"] = problemResponse(http.StatusForbidden)
+	}
+
+	operation.Responses["default"] = openAPIResponse{
+		Description: "Error",
+		Content: map[string]openAPIMediaType{
+			ContentTypeProblem: {Schema: &jsonSchema{Ref: "#/components/schemas/" + problemSchemaName}},
+		},
+	}
+}
+
+// problemResponse returns a response with problem details.
+func problemResponse(status int) openAPIResponse {
+	return openAPIResponse{
+		Description: http.StatusText(status),
+		Content: map[string]openAPIMediaType{
+			ContentTypeProblem: {Schema: &jsonSchema{Ref: "#/components/schemas/" + problemSchemaName}},
+		},
+	}
+}
+
+// problemSchema returns the schema of the problem details written by WriteError.
+func problemSchema() *jsonSchema {
+	return &jsonSchema{
+		Type: "object",
+		Properties: map[string]*jsonSchema{
+			"type":       {Type: "string", Format: "uri-reference"},
+			"title":      {Type: "string"},
+			"status":     {Type: "integer"},
+			"detail":     {Type: "string"},
+			"instance":   {Type: "string"},
+			"code":       {Type: "string"},
+			"request_id": {Type: "string"},
+			"errors": {
+				Type: "array",
+				Items: &jsonSchema{
+					Type: "object",
+					Properties: map[string]*jsonSchema{
+						"field":   {Type: "string"},
+						"message": {Type: "string"},
+					},
+					Required: []string{"field", "message"},
+				},
+			},
+		},
+		Required: []string{"type", "title", "status"},
+	}
+}
+
+// schemaGenerator creates JSON schemas from Go types. Named struct types are added to
+// the components and referenced.
+type schemaGenerator struct {
+	schemas map[string]*jsonSchema
+	names   map[reflect.Type]string
+}
+
+func newSchemaGenerator() *schemaGenerator {
+	return &schemaGenerator{
+		schemas: map[string]*jsonSchema{},
+		names:   map[reflect.Type]string{},
+	}
+}
+
+// schema returns the schema of a type as encoded by encoding/json.
+func (g *schemaGenerator) schema(t reflect.Type) *jsonSchema {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == timeType:
+		return &jsonSchema{Type: "string", Format: "date-time"}
+	case t.Implements(jsonMarshalerType) || reflect.PointerTo(t).Implements(jsonMarshalerType):
+		// the encoding is not known
+		return &jsonSchema{}
+	case t.Implements(textMarshalerType) || reflect.PointerTo(t).Implements(textMarshalerType):
+		return &jsonSchema{Type: "string"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &jsonSchema{Type: "boolean"}
+	case reflect.Int8, reflect.Int16, reflect.Int32:
+		return &jsonSchema{Type: "integer", Format: "int32"}
+	case reflect.Int, reflect.Int64:
+		return &jsonSchema{Type: "integer", Format: "int64"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		zero := 0.0
+		return &jsonSchema{Type: "integer", Minimum: &zero}
+	case reflect.Float32:
+		return &jsonSchema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &jsonSchema{Type: "number", Format: "double"}
+	case reflect.String:
+		return &jsonSchema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			// byte slices are encoded as base64 strings
+			return &jsonSchema{Type: "string", ContentEncoding: "base64"}
+		}
+		return &jsonSchema{Type: "array", Items: g.schema(t.Elem())}
+	case reflect.Map:
+		return &jsonSchema{Type: "object", AdditionalProperties: g.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.structSchema(t)
+		}
+		return &jsonSchema{Ref: "#/components/schemas/" + g.component(t)}
+	}
+
+	// interfaces can contain any value
+	return &jsonSchema{}
+}
+
+// paramSchema returns the schema of a request parameter. Unlike in JSON, durations are strings.
+func (g *schemaGenerator) paramSchema(t reflect.Type) *jsonSchema {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == durationType:
+		return &jsonSchema{Type: "string", Format: "duration"}
+	case t.Kind() == reflect.Slice && !reflect.PointerTo(t).Implements(textUnmarshalerType):
+		return &jsonSchema{Type: "array", Items: g.paramSchema(t.Elem())}
+	}
+	return g.schema(t)
+}
+
+// component adds the schema of a named struct type to the components and returns its name.
+func (g *schemaGenerator) component(t reflect.Type) string {
+	if name, ok := g.names[t]; ok {
+		return name
+	}
+
+	name := schemaNamePattern.ReplaceAllString(t.Name(), "_")
+	if _, taken := g.schemas[name]; taken {
+		// another type has the same name, use the package path as well
+		name = schemaNamePattern.ReplaceAllString(t.PkgPath()+"."+t.Name(), "_")
+	}
+
+	// register the name before the fields, so recursive types reference themselves
+	g.names[t] = name
+	g.schemas[name] = &jsonSchema{}
+	*g.schemas[name] = *g.structSchema(t)
+	return name
+}
+
+// structSchema returns the schema of the fields of a struct, following the rules of encoding/json.
+func (g *schemaGenerator) structSchema(t reflect.Type) *jsonSchema {
+	schema := &jsonSchema{Type: "object", Properties: map[string]*jsonSchema{}}
+
+	for _, field := range reflect.VisibleFields(t) {
+		if !field.IsExported() {
+			continue
+		}
+
+		name, ok := jsonName(field)
+		if !ok || !encodedField(t, field) {
+			continue
+		}
+
+		rules, _ := parseValidateTag(field.Tag.Get("validate"))
+		property := g.schema(field.Type)
+		applyRules(property, field.Type, rules)
+		schema.Properties[name] = property
+
+		if hasRule(rules, "required") {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+
+	sort.Strings(schema.Required)
+	return schema
+}
+
+// jsonName returns the name of the field in JSON. It returns false if the field is not encoded.
+func jsonName(field reflect.StructField) (string, bool) {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "-" {
+		return "", false
+	}
+	if name == "" {
+		return field.Name, true
+	}
+	return name, true
+}
+
+// encodedField reports whether encoding/json encodes the field as a property of t.
+// Embedded structs without a JSON name are not encoded themselves, their fields are promoted.
+func encodedField(t reflect.Type, field reflect.StructField) bool {
+	if isPromoting(field) {
+		return false
+	}
+
+	// promoted fields are only encoded if all embedding fields promote them
+	for i := 1; i < len(field.Index); i++ {
+		if !isPromoting(t.FieldByIndex(field.Index[:i])) {
+			return false
+		}
+	}
+	return true
+}
+
+// isPromoting reports whether the field is an embedded struct without a JSON name.
+func isPromoting(field reflect.StructField) bool {
+	if !field.Anonymous || field.Tag.Get("json") != "" {
+		return false
+	}
+
+	t := field.Type
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Struct
+}
+
+// hasRule reports whether the rules contain a rule with the name.
+func hasRule(rules []validationRule, name string) bool {
+	for _, rule := range rules {
+		if rule.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// applyRules adds the constraints of validate rules to the schema of a field.
+func applyRules(schema *jsonSchema, t reflect.Type, rules []validationRule) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	for _, rule := range rules {
+		switch rule.name {
+		case "min", "max":
+			limit, _ := strconv.ParseFloat(rule.param, 64)
+			var min, max **float64
+			switch t.Kind() {
+			case reflect.String:
+				min, max = &schema.MinLength, &schema.MaxLength
+			case reflect.Slice, reflect.Array:
+				min, max = &schema.MinItems, &schema.MaxItems
+			case reflect.Map:
+				min, max = &schema.MinProperties, &schema.MaxProperties
+			default:
+				min, max = &schema.Minimum, &schema.Maximum
+			}
+			if rule.name == "min" {
+				*min = &limit
+			} else {
+				*max = &limit
+			}
+
+		case "enum":
+			for _, value := range strings.Split(rule.param, "|") {
+				if number, err := strconv.ParseFloat(value, 64); err == nil && schema.Type != "string" {
+					schema.Enum = append(schema.Enum, number)
+				} else {
+					schema.Enum = append(schema.Enum, value)
+				}
+			}
+
+		case "regex":
+			schema.Pattern = rule.param
+		}
+	}
+}
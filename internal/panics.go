@@ -0,0 +1,45 @@
+package internal
+
+import (
+	"sync"
+
+	"github.com/karotte128/karotteapi"
+)
+
+// panic_hooks are called for every panic caught by the recovery middleware.
+var panic_hooks []func(info karotteapi.PanicInfo)
+
+// panicHookLock guards panic_hooks.
+var panicHookLock sync.RWMutex
+
+// RegisterPanicHook adds a function that is called for every panic caught by the recovery middleware.
+func RegisterPanicHook(hook func(info karotteapi.PanicInfo)) {
+	panicHookLock.Lock()
+	defer panicHookLock.Unlock()
+
+	panic_hooks = append(panic_hooks, hook)
+}
+
+// ReportPanic calls all panic hooks.
+// It makes sure that a panic in a hook does not crash the server.
+func ReportPanic(info karotteapi.PanicInfo) {
+	panicHookLock.RLock()
+	hooks := panic_hooks
+	panicHookLock.RUnlock()
+
+	for _, hook := range hooks {
+		safePanicHook(hook, info)
+	}
+}
+
+// safePanicHook executes a panic hook and recovers from a panic in it.
+func safePanicHook(hook func(info karotteapi.PanicInfo), info karotteapi.PanicInfo) {
+	defer func() {
+		r := recover()
+		if r != nil {
+			Logger().Error("panic hook panicked", "panic", r, "error_id", info.ErrorID)
+		}
+	}()
+
+	hook(info)
+}
@@ -0,0 +1,134 @@
+package internal
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/karotte128/karotteapi"
+)
+
+// permissionProvider is the provider used to check permissions.
+var permissionProvider karotteapi.PermissionProvider
+
+// permissionLock guards permissionProvider.
+var permissionLock sync.RWMutex
+
+// SetPermissionProvider sets the provider used to check permissions.
+func SetPermissionProvider(provider karotteapi.PermissionProvider) {
+	permissionLock.Lock()
+	defer permissionLock.Unlock()
+
+	permissionProvider = provider
+}
+
+// ReplacePermissionProvider sets the provider if the current provider is old.
+// It reports whether the provider was replaced.
+func ReplacePermissionProvider(old, provider karotteapi.PermissionProvider) bool {
+	permissionLock.Lock()
+	defer permissionLock.Unlock()
+
+	if permissionProvider != old {
+		return false
+	}
+	permissionProvider = provider
+	return true
+}
+
+// GetPermissionProvider returns the provider used to check permissions.
+func GetPermissionProvider() (karotteapi.PermissionProvider, bool) {
+	permissionLock.RLock()
+	defer permissionLock.RUnlock()
+
+	return permissionProvider, permissionProvider != nil
+}
+
+// ErrNoPermissionProvider is returned if a permission is checked without a permission provider.
+var ErrNoPermissionProvider = errors.New("no permission provider set")
+
+// HasPermissions checks if the principal has all permissions.
+func HasPermissions(principal *karotteapi.Principal, permissions ...string) (bool, error) {
+	provider, ok := GetPermissionProvider()
+	if !ok {
+		return false, ErrNoPermissionProvider
+	}
+
+	for _, permission := range permissions {
+		allowed, err := provider.HasPermission(principal, permission)
+		if err != nil || !allowed {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// Authorize checks if the principal of the request has all permissions.
+// If not, it writes the error response (401, 403 or 500) and returns false.
+func Authorize(w http.ResponseWriter, r *http.Request, permissions ...string) bool {
+	principal, ok := GetPrincipal(r.Context())
+	if !ok {
+		WriteError(w, r, NewHTTPError(http.StatusUnauthorized, "unauthenticated", "authentication required"))
+		return false
+	}
+
+	allowed, err := HasPermissions(principal, permissions...)
+	if err != nil {
+		Logger().ErrorContext(r.Context(), "permission check failed", "method", r.Method, "path", r.URL.Path, "error", err)
+		WriteError(w, r, NewHTTPError(http.StatusInternalServerError, "authorization_unavailable", "authorization is not available"))
+		return false
+	}
+
+	if !allowed {
+		WriteError(w, r, NewHTTPError(http.StatusForbidden, "forbidden", "missing permission"))
+		return false
+	}
+
+	return true
+}
+
+// Require returns a handler that only calls the handler if the principal of the request has all permissions.
+func Require(handler http.Handler, permissions ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if Authorize(w, r, permissions...) {
+			handler.ServeHTTP(w, r)
+		}
+	})
+}
+
+// RolePermissionProvider grants permissions to principals by their roles.
+// Permissions are matched with wildcards: "*" grants everything and
+// "orders:*" grants every permission starting with "orders:".
+type RolePermissionProvider struct {
+	roles map[string][]string
+}
+
+// NewRolePermissionProvider creates a provider from a map of role name to permissions.
+func NewRolePermissionProvider(roles map[string][]string) *RolePermissionProvider {
+	return &RolePermissionProvider{roles: roles}
+}
+
+func (p *RolePermissionProvider) HasPermission(principal *karotteapi.Principal, permission string) (bool, error) {
+	if principal == nil {
+		return false, nil
+	}
+
+	for _, role := range principal.Roles {
+		for _, granted := range p.roles[role] {
+			if matchPermission(granted, permission) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// matchPermission reports whether the granted permission covers the permission.
+func matchPermission(granted string, permission string) bool {
+	if granted == "*" || granted == permission {
+		return true
+	}
+
+	prefix, ok := strings.CutSuffix(granted, "*")
+	return ok && strings.HasPrefix(permission, prefix)
+}
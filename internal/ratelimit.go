@@ -0,0 +1,215 @@
+package internal
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/karotte128/karotteapi"
+)
+
+// rateLimitStore is the store used by the ratelimit middleware.
+var rateLimitStore karotteapi.RateLimitStore = NewMemoryRateLimitStore(0)
+
+// rateLimitLock guards rateLimitStore.
+var rateLimitLock sync.RWMutex
+
+// SetRateLimitStore sets the store used by the ratelimit middleware.
+func SetRateLimitStore(store karotteapi.RateLimitStore) {
+	rateLimitLock.Lock()
+	defer rateLimitLock.Unlock()
+
+	rateLimitStore = store
+}
+
+// GetRateLimitStore returns the store used by the ratelimit middleware.
+func GetRateLimitStore() karotteapi.RateLimitStore {
+	rateLimitLock.RLock()
+	defer rateLimitLock.RUnlock()
+
+	return rateLimitStore
+}
+
+// MemoryRateLimitStore keeps the rate limits in memory.
+// It is only suitable for a single instance of the API.
+// The number of keys is limited, so clients can not fill the memory with new keys
+// (e.g. with a header key). If the store is full, the least recently used key is evicted.
+type MemoryRateLimitStore struct {
+	lock        sync.Mutex
+	entries     map[string]*list.Element
+	lastCleanup time.Time
+	maxKeys     int
+
+	// recent holds the entries, the most recently used first.
+	recent *list.List
+}
+
+// rateLimitEntry is the state of a single key.
+type rateLimitEntry struct {
+	key string
+
+	// token bucket
+	tokens float64
+	last   time.Time
+
+	// sliding window
+	windowStart time.Time
+	current     int
+	previous    int
+
+	// expires is the time after which the entry is back at its initial state.
+	expires time.Time
+}
+
+// cleanupInterval is the interval in which expired entries are removed.
+const cleanupInterval = time.Minute
+
+// defaultMaxRateLimitKeys is the number of keys a MemoryRateLimitStore holds by default.
+const defaultMaxRateLimitKeys = 100_000
+
+// NewMemoryRateLimitStore creates an in-memory rate limit store that holds at most maxKeys keys.
+// If maxKeys is 0 or less, 100000 keys are held.
+func NewMemoryRateLimitStore(maxKeys int) *MemoryRateLimitStore {
+	if maxKeys <= 0 {
+		maxKeys = defaultMaxRateLimitKeys
+	}
+
+	return &MemoryRateLimitStore{
+		entries: map[string]*list.Element{},
+		maxKeys: maxKeys,
+		recent:  list.New(),
+	}
+}
+
+func (s *MemoryRateLimitStore) Take(ctx context.Context, key string, limit karotteapi.RateLimit) (karotteapi.RateLimitResult, error) {
+	if limit.Limit <= 0 || limit.Window <= 0 {
+		return karotteapi.RateLimitResult{}, fmt.Errorf("invalid rate limit %d per %s", limit.Limit, limit.Window)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	now := time.Now()
+	s.cleanup(now)
+
+	var entry *rateLimitEntry
+	element, ok := s.entries[key]
+	if ok {
+		s.recent.MoveToFront(element)
+		entry = element.Value.(*rateLimitEntry)
+	} else {
+		entry = &rateLimitEntry{key: key}
+		s.entries[key] = s.recent.PushFront(entry)
+
+		if s.recent.Len() > s.maxKeys {
+			oldest := s.recent.Remove(s.recent.Back()).(*rateLimitEntry)
+			delete(s.entries, oldest.key)
+		}
+	}
+
+	switch limit.Algorithm {
+	case "", "token_bucket":
+		return entry.takeToken(now, limit), nil
+	case "sliding_window":
+		return entry.takeWindow(now, limit), nil
+	}
+
+	return karotteapi.RateLimitResult{}, fmt.Errorf("unknown rate limit algorithm %q", limit.Algorithm)
+}
+
+// cleanup removes expired entries, at most once per cleanupInterval.
+func (s *MemoryRateLimitStore) cleanup(now time.Time) {
+	if now.Sub(s.lastCleanup) < cleanupInterval {
+		return
+	}
+	s.lastCleanup = now
+
+	for key, element := range s.entries {
+		if now.After(element.Value.(*rateLimitEntry).expires) {
+			s.recent.Remove(element)
+			delete(s.entries, key)
+		}
+	}
+}
+
+// takeToken takes a token from the bucket. The bucket holds Burst tokens
+// and is refilled with Limit tokens per Window.
+func (e *rateLimitEntry) takeToken(now time.Time, limit karotteapi.RateLimit) karotteapi.RateLimitResult {
+	capacity := float64(limit.Burst)
+	if capacity <= 0 {
+		capacity = float64(limit.Limit)
+	}
+	rate := float64(limit.Limit) / limit.Window.Seconds() // tokens per second
+
+	if e.last.IsZero() {
+		e.tokens = capacity
+	} else {
+		e.tokens = math.Min(capacity, e.tokens+now.Sub(e.last).Seconds()*rate)
+	}
+	e.last = now
+
+	var result karotteapi.RateLimitResult
+	if e.tokens >= 1 {
+		e.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = seconds((1 - e.tokens) / rate)
+	}
+
+	result.Remaining = int(e.tokens)
+	result.Reset = seconds((capacity - e.tokens) / rate)
+	e.expires = now.Add(result.Reset)
+
+	return result
+}
+
+// takeWindow counts the request in a sliding window. The count is estimated from
+// the current window and the previous window weighted by its overlap.
+func (e *rateLimitEntry) takeWindow(now time.Time, limit karotteapi.RateLimit) karotteapi.RateLimitResult {
+	start := now.Truncate(limit.Window)
+
+	switch {
+	case e.windowStart.Equal(start):
+	case e.windowStart.Add(limit.Window).Equal(start):
+		e.previous = e.current
+		e.current = 0
+	default:
+		e.previous = 0
+		e.current = 0
+	}
+	e.windowStart = start
+
+	elapsed := now.Sub(start).Seconds() / limit.Window.Seconds()
+	estimate := float64(e.previous)*(1-elapsed) + float64(e.current)
+
+	var result karotteapi.RateLimitResult
+	if estimate+1 <= float64(limit.Limit) {
+		e.current++
+		estimate++
+		result.Allowed = true
+	} else if e.current >= limit.Limit {
+		// the current window is full, wait for the next one
+		result.RetryAfter = start.Add(limit.Window).Sub(now)
+	} else {
+		// wait until the previous window has moved out far enough
+		free := 1 - (float64(limit.Limit)-float64(e.current)-1)/float64(e.previous)
+		result.RetryAfter = seconds((free - elapsed) * limit.Window.Seconds())
+	}
+
+	result.Remaining = max(0, limit.Limit-int(math.Ceil(estimate)))
+	result.Reset = start.Add(2 * limit.Window).Sub(now)
+	if e.current == 0 {
+		result.Reset = start.Add(limit.Window).Sub(now)
+	}
+	e.expires = start.Add(2 * limit.Window)
+
+	return result
+}
+
+// seconds converts seconds to a duration.
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
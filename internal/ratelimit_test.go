@@ -0,0 +1,129 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/karotte128/karotteapi"
+)
+
+func TestTokenBucket(t *testing.T) {
+	limit := karotteapi.RateLimit{Algorithm: "token_bucket", Limit: 10, Window: 10 * time.Second, Burst: 3}
+	entry := &rateLimitEntry{}
+	now := time.Now()
+
+	// the burst is available right away
+	for i := range 3 {
+		result := entry.takeToken(now, limit)
+		if !result.Allowed || result.Remaining != 2-i {
+			t.Fatalf("request %d: %+v", i, result)
+		}
+	}
+
+	result := entry.takeToken(now, limit)
+	if result.Allowed || result.RetryAfter != time.Second {
+		t.Errorf("empty bucket: %+v", result)
+	}
+
+	// one token per second is refilled
+	result = entry.takeToken(now.Add(time.Second), limit)
+	if !result.Allowed || result.Remaining != 0 {
+		t.Errorf("after one second: %+v", result)
+	}
+
+	// the bucket is never filled above the burst
+	result = entry.takeToken(now.Add(time.Hour), limit)
+	if !result.Allowed || result.Remaining != 2 {
+		t.Errorf("after one hour: %+v", result)
+	}
+}
+
+func TestSlidingWindow(t *testing.T) {
+	limit := karotteapi.RateLimit{Algorithm: "sliding_window", Limit: 4, Window: time.Minute}
+	entry := &rateLimitEntry{}
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	for i := range 4 {
+		if result := entry.takeWindow(start.Add(time.Duration(i)*time.Second), limit); !result.Allowed {
+			t.Fatalf("request %d: %+v", i, result)
+		}
+	}
+
+	result := entry.takeWindow(start.Add(30*time.Second), limit)
+	if result.Allowed || result.RetryAfter != 30*time.Second {
+		t.Errorf("full window: %+v", result)
+	}
+
+	// in the middle of the next window, half of the previous window is still counted
+	next := start.Add(90 * time.Second)
+	for i := range 2 {
+		if result := entry.takeWindow(next, limit); !result.Allowed {
+			t.Fatalf("next window, request %d: %+v", i, result)
+		}
+	}
+	if result := entry.takeWindow(next, limit); result.Allowed {
+		t.Errorf("next window over the limit: %+v", result)
+	}
+
+	// after two windows nothing of the old requests is counted
+	later := start.Add(3 * time.Minute)
+	result = entry.takeWindow(later, limit)
+	if !result.Allowed || result.Remaining != 3 {
+		t.Errorf("after two windows: %+v", result)
+	}
+}
+
+func TestMemoryRateLimitStoreEvictsLeastRecentlyUsed(t *testing.T) {
+	store := NewMemoryRateLimitStore(2)
+	limit := karotteapi.RateLimit{Algorithm: "token_bucket", Limit: 1, Window: time.Hour}
+	ctx := context.Background()
+
+	take := func(key string) bool {
+		t.Helper()
+
+		result, err := store.Take(ctx, key, limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result.Allowed
+	}
+
+	take("a")
+	take("b")
+	take("a") // a is now used more recently than b
+	take("c") // evicts b
+
+	if len(store.entries) != 2 || store.recent.Len() != 2 {
+		t.Fatalf("store holds %d keys", len(store.entries))
+	}
+	if take("a") {
+		t.Error("a was evicted")
+	}
+	if !take("b") {
+		t.Error("b was not evicted")
+	}
+
+	for i := range 100 {
+		take(fmt.Sprintf("header:X-Key:%d", i))
+	}
+	if len(store.entries) != 2 {
+		t.Errorf("store holds %d keys", len(store.entries))
+	}
+}
+
+func TestMemoryRateLimitStoreErrors(t *testing.T) {
+	store := NewMemoryRateLimitStore(0)
+	ctx := context.Background()
+
+	for _, limit := range []karotteapi.RateLimit{
+		{Algorithm: "token_bucket", Limit: 0, Window: time.Minute},
+		{Algorithm: "token_bucket", Limit: 1},
+		{Algorithm: "leaky_bucket", Limit: 1, Window: time.Minute},
+	} {
+		if _, err := store.Take(ctx, "key", limit); err == nil {
+			t.Errorf("%+v: no error", limit)
+		}
+	}
+}
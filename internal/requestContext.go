@@ -2,22 +2,108 @@ package internal
 
 import (
 	"context"
+	"sync"
 
 	"github.com/karotte128/karotteapi"
 )
 
+// requestContextKey is the type of all keys the framework stores in the request context.
+// Using an own type avoids collisions with context values of other packages using the same string.
+type requestContextKey string
+
+// requestContextValue wraps a value in the request context.
+// This allows to distinguish a missing value from a value that was set to nil.
+type requestContextValue struct {
+	value any
+}
+
+// contextTracker records all values set in the request context of a request.
+// It is only added to the request context in debug mode.
+type contextTracker struct {
+	lock    sync.Mutex
+	entries []karotteapi.RequestContext
+}
+
+// trackerKey is the request context key of the contextTracker.
+type trackerKey struct{}
+
+// SetContextValue adds the value under the name to the request context.
+func SetContextValue(ctx context.Context, name string, value any) context.Context {
+	return SetKeyedContextValue(ctx, requestContextKey(name), name, value)
+}
+
+// SetKeyedContextValue adds the value under the key to the request context.
+// The name is only used to list the value for debugging.
+func SetKeyedContextValue(ctx context.Context, key any, name string, value any) context.Context {
+	tracker, ok := ctx.Value(trackerKey{}).(*contextTracker)
+	if ok {
+		tracker.lock.Lock()
+		tracker.entries = append(tracker.entries, karotteapi.RequestContext{Info: value, ContextKey: name})
+		tracker.lock.Unlock()
+	}
+
+	return context.WithValue(ctx, key, requestContextValue{value: value})
+}
+
+// TrackRequestContext adds a tracker to the request context that records all values
+// set through the framework from now on. It is used for debugging.
+func TrackRequestContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, trackerKey{}, &contextTracker{})
+}
+
+// ListRequestContext returns all values set through the framework since TrackRequestContext was called,
+// in the order they were set. It returns nil if the request context is not tracked.
+func ListRequestContext(ctx context.Context) []karotteapi.RequestContext {
+	tracker, ok := ctx.Value(trackerKey{}).(*contextTracker)
+	if !ok {
+		return nil
+	}
+
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+
+	entries := make([]karotteapi.RequestContext, len(tracker.entries))
+	copy(entries, tracker.entries)
+	return entries
+}
+
+// GetContextValue retrieves the value with the name from the request context.
+// It returns false if no value was set.
+func GetContextValue(ctx context.Context, name string) (any, bool) {
+	return GetKeyedContextValue(ctx, requestContextKey(name))
+}
+
+// GetKeyedContextValue retrieves the value with the key from the request context.
+// It returns false if no value was set.
+func GetKeyedContextValue(ctx context.Context, key any) (any, bool) {
+	value, ok := ctx.Value(key).(requestContextValue)
+	if !ok {
+		return nil, false
+	}
+	return value.value, true
+}
+
 // This adds the info to the request data.
 // It is usually used by a middleware.
 func SetRequestContext(ctx context.Context, info *karotteapi.RequestContext) context.Context {
-	return context.WithValue(ctx, info.ContextKey, info.Info)
+	return SetContextValue(ctx, info.ContextKey, info.Info)
 }
 
 // This retrieves the info from the request context.
 // It is usually used in a module.
 func GetRequestContext(ctx context.Context, contextKey string) karotteapi.RequestContext {
+	requestContext, _ := LookupRequestContext(ctx, contextKey)
+	return requestContext
+}
+
+// This retrieves the info from the request context.
+// It returns false if no info was set.
+func LookupRequestContext(ctx context.Context, contextKey string) (karotteapi.RequestContext, bool) {
+	info, ok := GetContextValue(ctx, contextKey)
+
 	requestContext := karotteapi.RequestContext{
-		Info:       ctx.Value(contextKey),
+		Info:       info,
 		ContextKey: contextKey,
 	}
-	return requestContext
+	return requestContext, ok
 }
@@ -0,0 +1,71 @@
+package internal
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"time"
+)
+
+// RequestIDContextKey is the request context key of the request id.
+const RequestIDContextKey = "requestid"
+
+// SetRequestID adds the request id to the request context.
+func SetRequestID(ctx context.Context, id string) context.Context {
+	return SetContextValue(ctx, RequestIDContextKey, id)
+}
+
+// GetRequestID retrieves the request id from the request context.
+// It returns an empty string if the request has no id.
+func GetRequestID(ctx context.Context) string {
+	value, _ := GetContextValue(ctx, RequestIDContextKey)
+	id, _ := value.(string)
+	return id
+}
+
+// NewUUIDv7 generates a UUID version 7 (RFC 9562).
+// It starts with the unix time in milliseconds, so the ids sort by creation time.
+func NewUUIDv7() string {
+	var uuid [16]byte
+	rand.Read(uuid[6:])
+
+	binary.BigEndian.PutUint64(uuid[:8], uint64(time.Now().UnixMilli())<<16|uint64(binary.BigEndian.Uint16(uuid[6:8])))
+	uuid[6] = 0x70 | uuid[6]&0x0f // version 7
+	uuid[8] = 0x80 | uuid[8]&0x3f // variant 10
+
+	var buf [36]byte
+	hex.Encode(buf[0:8], uuid[0:4])
+	buf[8] = '-'
+	hex.Encode(buf[9:13], uuid[4:6])
+	buf[13] = '-'
+	hex.Encode(buf[14:18], uuid[6:8])
+	buf[18] = '-'
+	hex.Encode(buf[19:23], uuid[8:10])
+	buf[23] = '-'
+	hex.Encode(buf[24:], uuid[10:])
+	return string(buf[:])
+}
+
+// crockford is the base32 alphabet used by ULIDs.
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// NewULID generates a ULID: 48 bits unix time in milliseconds and 80 random bits,
+// encoded as 26 characters of Crockford base32.
+func NewULID() string {
+	var id [16]byte
+	binary.BigEndian.PutUint64(id[:8], uint64(time.Now().UnixMilli())<<16)
+	rand.Read(id[6:])
+
+	// encode 128 bits as 26 characters of 5 bits, the first character only has 3 bits
+	hi := binary.BigEndian.Uint64(id[:8])
+	lo := binary.BigEndian.Uint64(id[8:])
+
+	var buf [26]byte
+	for i := 25; i >= 0; i-- {
+		buf[i] = crockford[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(buf[:])
+}
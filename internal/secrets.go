@@ -0,0 +1,202 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/karotte128/karotteapi"
+)
+
+// Config values can reference secrets instead of containing them:
+//
+//   "secret://file/run/secrets/db_pass" reads the file /run/secrets/db_pass
+//   "secret://env/DB_PASS"              reads the environment variable DB_PASS
+//
+// The part after "secret://" is "<provider>/<reference>". The reference is
+// resolved by the SecretProvider registered under the provider name.
+// References are resolved when the config is loaded or reloaded. If a reference
+// can not be resolved, the load or reload fails. The stored config only ever
+// contains the references, the resolved copy is only handed to modules and middleware.
+
+// secretPrefix is the prefix of config values that reference a secret.
+const secretPrefix = "secret://"
+
+// redactedValue replaces sensitive values when the config is exposed.
+const redactedValue = "[REDACTED]"
+
+// secret_providers holds all registered secret providers by name.
+var secret_providers = map[string]karotteapi.SecretProvider{
+	"file": fileSecretProvider{},
+	"env":  envSecretProvider{},
+}
+
+// secretLock guards secret_providers.
+var secretLock sync.RWMutex
+
+// RegisterSecretProvider registers a secret provider under a name.
+// A provider registered under an existing name replaces it.
+// The provider is used from the next config load or reload on.
+func RegisterSecretProvider(name string, provider karotteapi.SecretProvider) {
+	secretLock.Lock()
+	defer secretLock.Unlock()
+
+	secret_providers[name] = provider
+}
+
+// getResolvedBlock returns the config block at path of the current config
+// with all secret references resolved.
+func getResolvedBlock(path ...string) (karotteapi.Config, bool) {
+	key := strings.Join(path, ".")
+	recordConsumed(key)
+
+	return getConfigBlock(getResolvedConfig(), path...)
+}
+
+// resolveConfig returns a copy of the config with all secret references resolved.
+// The error joins the errors of all references that can not be resolved.
+func resolveConfig(conf karotteapi.Config) (karotteapi.Config, error) {
+	if conf == nil {
+		return nil, nil
+	}
+
+	var errs []error
+	resolved := resolveSecrets(map[string]any(conf), "", &errs).(map[string]any)
+	return resolved, errors.Join(errs...)
+}
+
+// isSecretReference reports whether value references a secret.
+func isSecretReference(value any) bool {
+	s, ok := value.(string)
+	return ok && strings.HasPrefix(s, secretPrefix)
+}
+
+// resolveSecret resolves a single secret reference.
+func resolveSecret(reference string) (string, error) {
+	name, ref, ok := strings.Cut(strings.TrimPrefix(reference, secretPrefix), "/")
+	if !ok || ref == "" {
+		return "", fmt.Errorf("invalid secret reference %q", reference)
+	}
+
+	secretLock.RLock()
+	provider, ok := secret_providers[name]
+	secretLock.RUnlock()
+	if !ok {
+		return "", fmt.Errorf("unknown secret provider %q", name)
+	}
+
+	return provider.Resolve(ref)
+}
+
+// resolveSecrets returns a copy of value with all secret references resolved.
+// A reference that can not be resolved is kept and the error is added to errs.
+func resolveSecrets(value any, path string, errs *[]error) any {
+	switch val := value.(type) {
+	case map[string]any:
+		resolved := make(map[string]any, len(val))
+		for k, v := range val {
+			key := k
+			if path != "" {
+				key = path + "." + k
+			}
+			resolved[k] = resolveSecrets(v, key, errs)
+		}
+		return resolved
+
+	case []any:
+		resolved := make([]any, len(val))
+		for i, v := range val {
+			resolved[i] = resolveSecrets(v, fmt.Sprintf("%s[%d]", path, i), errs)
+		}
+		return resolved
+
+	default:
+		if !isSecretReference(value) {
+			return value
+		}
+
+		secret, err := resolveSecret(value.(string))
+		if err != nil {
+			*errs = append(*errs, fmt.Errorf("%s: %w", path, err))
+			return value
+		}
+		return secret
+	}
+}
+
+// RedactConfig returns a copy of the config with all secret references and
+// the values of sensitive keys (e.g. password, token, db_password, api_key) redacted.
+// It should be used whenever the config is logged or exposed.
+func RedactConfig(conf karotteapi.Config) karotteapi.Config {
+	if conf == nil {
+		return nil
+	}
+
+	return redact(map[string]any(conf)).(map[string]any)
+}
+
+func redact(value any) any {
+	switch val := value.(type) {
+	case map[string]any:
+		redacted := make(map[string]any, len(val))
+		for k, v := range val {
+			if isSensitiveKey(k) {
+				redacted[k] = redactedValue
+				continue
+			}
+			redacted[k] = redact(v)
+		}
+		return redacted
+
+	case []any:
+		redacted := make([]any, len(val))
+		for i, v := range val {
+			redacted[i] = redact(v)
+		}
+		return redacted
+
+	default:
+		if isSecretReference(value) || isPasswordHash(value) {
+			return redactedValue
+		}
+		return value
+	}
+}
+
+// isPasswordHash reports whether value is a bcrypt hash, e.g. of a user of the basic authenticator
+// ([middleware.auth.basic.users] alice = "$2a$10$..."), whose key is the user name.
+func isPasswordHash(value any) bool {
+	s, ok := value.(string)
+	return ok && len(s) == 60 && (strings.HasPrefix(s, "$2a$") || strings.HasPrefix(s, "$2b$") || strings.HasPrefix(s, "$2y$"))
+}
+
+// GetRedactedConfig returns the current config with all secret references and sensitive values redacted.
+func GetRedactedConfig() karotteapi.Config {
+	return RedactConfig(getConfig())
+}
+
+// fileSecretProvider reads the secret from a file.
+// The reference is the absolute path of the file without the leading slash.
+// Trailing newlines are removed.
+type fileSecretProvider struct{}
+
+func (fileSecretProvider) Resolve(reference string) (string, error) {
+	data, err := os.ReadFile("/" + reference)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// envSecretProvider reads the secret from an environment variable.
+type envSecretProvider struct{}
+
+func (envSecretProvider) Resolve(reference string) (string, error) {
+	value, ok := os.LookupEnv(reference)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s is not set", reference)
+	}
+	return value, nil
+}
@@ -0,0 +1,73 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/karotte128/karotteapi"
+)
+
+func TestLoadConfigResolvesSecrets(t *testing.T) {
+	t.Setenv("TEST_SECRET", "s3cret")
+
+	err := LoadConfig(karotteapi.Config{
+		"modules": map[string]any{
+			"example": map[string]any{
+				"password": "secret://env/TEST_SECRET",
+				"tokens":   []any{"plain", "secret://env/TEST_SECRET"},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block, ok := GetModuleConfig("example")
+	if !ok || block["password"] != "s3cret" || block["tokens"].([]any)[1] != "s3cret" {
+		t.Errorf("block = %v", block)
+	}
+
+	// the stored config keeps the reference
+	if getConfig()["modules"].(map[string]any)["example"].(map[string]any)["password"] != "secret://env/TEST_SECRET" {
+		t.Error("the stored config contains the secret")
+	}
+}
+
+func TestUnresolvableSecretFailsLoad(t *testing.T) {
+	err := LoadConfig(karotteapi.Config{
+		"modules": map[string]any{
+			"example": map[string]any{"password": "secret://env/TEST_SECRET_MISSING"},
+		},
+	})
+	if err == nil || !strings.Contains(err.Error(), "modules.example.password") {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestUnresolvableSecretFailsReload(t *testing.T) {
+	t.Setenv("TEST_SECRET", "old")
+
+	err := LoadConfig(karotteapi.Config{
+		"modules": map[string]any{
+			"example": map[string]any{"password": "secret://env/TEST_SECRET"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ReloadConfig(karotteapi.Config{
+		"modules": map[string]any{
+			"example": map[string]any{"password": "secret://unknown/x"},
+		},
+	})
+	if err == nil {
+		t.Fatal("reload did not fail")
+	}
+
+	// the old config is kept
+	block, _ := GetModuleConfig("example")
+	if block["password"] != "old" {
+		t.Errorf("block = %v", block)
+	}
+}
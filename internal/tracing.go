@@ -0,0 +1,202 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/karotte128/karotteapi"
+)
+
+// Tracing is built on OpenTelemetry. ConfigureTracing creates an SDK tracer provider
+// with the configured exporter and sets it as the global provider, so libraries that
+// use OpenTelemetry (e.g. database drivers) join the traces of the framework.
+// Without an exporter, the global provider is left alone, so an application can set up
+// OpenTelemetry itself and the framework uses it.
+
+// tracerName is the instrumentation scope of the spans of the framework.
+const tracerName = "github.com/karotte128/karotteapi"
+
+// TraceExporterFactory creates a span exporter from the [server.tracing] config block.
+type TraceExporterFactory func(config karotteapi.Config) (sdktrace.SpanExporter, error)
+
+// trace_exporter_registry holds all registered trace exporters by name.
+var trace_exporter_registry = map[string]TraceExporterFactory{
+	"stdout": newStdoutTraceExporter,
+	"file":   newFileTraceExporter,
+	"otlp":   newOTLPTraceExporter,
+}
+
+// traceExporterLock guards trace_exporter_registry.
+var traceExporterLock sync.RWMutex
+
+// tracerProvider is the provider created by ConfigureTracing. It is nil if the framework did not create one.
+var tracerProvider atomic.Pointer[sdktrace.TracerProvider]
+
+func init() {
+	// the trace context is always propagated, also if no spans are exported
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+}
+
+// RegisterTraceExporter adds a trace exporter that can be selected with [server.tracing] exporter.
+func RegisterTraceExporter(name string, factory TraceExporterFactory) {
+	traceExporterLock.Lock()
+	defer traceExporterLock.Unlock()
+
+	trace_exporter_registry[name] = factory
+}
+
+// Tracer returns the tracer of the framework from the global tracer provider.
+// If tracing is disabled, its spans only propagate the trace context.
+func Tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// StartSpan starts an internal span as child of the current span of ctx.
+func StartSpan(ctx context.Context, name string) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, name)
+}
+
+// ConfigureTracing creates the tracer provider from the server config.
+//
+// [server.tracing]
+// exporter = "otlp"                                 # none, stdout, file, otlp or a registered exporter
+// sample_rate = 1.0                                 # fraction of new traces that are sampled
+// service_name = "karotteapi"
+// file = "traces.jsonl"                             # file exporter
+// endpoint = "http://localhost:4318/v1/traces"      # otlp exporter
+// headers = { Authorization = "secret://env/OTLP_AUTH" }   # otlp exporter
+func ConfigureTracing(serverConfig karotteapi.Config) error {
+	conf, _ := getConfigBlock(serverConfig, "tracing")
+
+	name, ok := GetNestedValue[string](conf, "exporter")
+	if !ok || name == "none" {
+		return nil
+	}
+
+	traceExporterLock.RLock()
+	factory, ok := trace_exporter_registry[name]
+	traceExporterLock.RUnlock()
+	if !ok {
+		return fmt.Errorf("unknown trace exporter %q", name)
+	}
+
+	exporter, err := factory(conf)
+	if err != nil {
+		return fmt.Errorf("trace exporter %s: %w", name, err)
+	}
+
+	sampleRate := 1.0
+	if rate, ok := GetNestedValue[float64](conf, "sample_rate"); ok {
+		sampleRate = rate
+	} else if rate, ok := GetNestedValue[int64](conf, "sample_rate"); ok {
+		sampleRate = float64(rate)
+	}
+
+	serviceName, ok := GetNestedValue[string](conf, "service_name")
+	if !ok {
+		serviceName = "karotteapi"
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		// spans with a parent use the sampling decision of the parent
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRate))),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", serviceName))),
+	)
+
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		Logger().Warn("tracing error", "exporter", name, "error", err)
+	}))
+	otel.SetTracerProvider(provider)
+	tracerProvider.Store(provider)
+
+	Logger().Info("tracing enabled", "exporter", name, "sample_rate", sampleRate)
+	return nil
+}
+
+// ShutdownTracing exports all remaining spans and shuts down the exporter.
+func ShutdownTracing(ctx context.Context) {
+	provider := tracerProvider.Load()
+	if provider == nil {
+		return
+	}
+
+	err := provider.Shutdown(ctx)
+	if err != nil {
+		Logger().Error("failed shutting down tracing", "error", err)
+	}
+}
+
+// newStdoutTraceExporter writes spans as JSON lines to stdout.
+func newStdoutTraceExporter(conf karotteapi.Config) (sdktrace.SpanExporter, error) {
+	return stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+}
+
+// fileTraceExporter writes spans as JSON lines to a file and closes it on shutdown.
+type fileTraceExporter struct {
+	*stdouttrace.Exporter
+	file *os.File
+}
+
+func (e fileTraceExporter) Shutdown(ctx context.Context) error {
+	err := e.Exporter.Shutdown(ctx)
+	if err != nil {
+		return err
+	}
+	return e.file.Close()
+}
+
+// newFileTraceExporter writes spans as JSON lines to a file.
+func newFileTraceExporter(conf karotteapi.Config) (sdktrace.SpanExporter, error) {
+	path, ok := GetNestedValue[string](conf, "file")
+	if !ok {
+		return nil, fmt.Errorf("no file configured")
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	exporter, err := stdouttrace.New(stdouttrace.WithWriter(file))
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return fileTraceExporter{Exporter: exporter, file: file}, nil
+}
+
+// newOTLPTraceExporter sends spans to an OpenTelemetry collector with OTLP over HTTP.
+func newOTLPTraceExporter(conf karotteapi.Config) (sdktrace.SpanExporter, error) {
+	endpoint, ok := GetNestedValue[string](conf, "endpoint")
+	if !ok {
+		endpoint = "http://localhost:4318/v1/traces"
+	}
+
+	headers := map[string]string{}
+	headerConf, _ := GetNestedValue[map[string]any](conf, "headers")
+	for key, value := range headerConf {
+		s, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("header %s is not a string", key)
+		}
+		headers[key] = s
+	}
+
+	return otlptracehttp.New(context.Background(),
+		otlptracehttp.WithEndpointURL(endpoint),
+		otlptracehttp.WithHeaders(headers),
+	)
+}
@@ -0,0 +1,282 @@
+package internal
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/karotte128/karotteapi"
+)
+
+// validationRule is a single rule of a validate tag, e.g. "min=3".
+type validationRule struct {
+	name  string
+	param string
+}
+
+// regexCache holds the compiled patterns of regex rules.
+var regexCache sync.Map
+
+// Validate checks the struct v against the validate tags of its fields:
+//
+//	Name  string   `json:"name" validate:"required,min=3,max=50"`
+//	Role  string   `json:"role" validate:"enum=admin|user"`
+//	Email string   `json:"email" validate:"regex=^[^@]+@[^@]+$"`
+//	Tags  []string `json:"tags" validate:"max=10"`
+//
+// required rejects zero values (nil for pointers), min and max check the value of numbers
+// and the length of strings, slices and maps, enum lists the allowed values separated by "|"
+// and regex must be the last rule, as the pattern can contain commas.
+// Nested structs, pointers and slices of structs are validated as well.
+//
+// It returns an HTTPError with status 422 and the failed fields, or a plain error if a tag is invalid.
+func Validate(v any) error {
+	var fields []karotteapi.FieldError
+	err := validateValue(reflect.ValueOf(v), "", &fields)
+	if err != nil {
+		return err
+	}
+
+	if len(fields) > 0 {
+		httpErr := NewHTTPError(http.StatusUnprocessableEntity, "validation_failed", "the request contains invalid fields")
+		httpErr.Fields = fields
+		return httpErr
+	}
+	return nil
+}
+
+// validateValue validates all fields of a struct, following pointers, slices and maps.
+func validateValue(v reflect.Value, path string, fields *[]karotteapi.FieldError) error {
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+
+			name := fieldName(field)
+			if path != "" {
+				name = path + "." + name
+			}
+
+			value := v.Field(i)
+			if tag, ok := field.Tag.Lookup("validate"); ok {
+				rules, err := parseValidateTag(tag)
+				if err != nil {
+					return fmt.Errorf("field %s: %w", name, err)
+				}
+
+				message, err := checkRules(value, rules)
+				if err != nil {
+					return fmt.Errorf("field %s: %w", name, err)
+				}
+				if message != "" {
+					*fields = append(*fields, karotteapi.FieldError{Field: name, Message: message})
+					continue
+				}
+			}
+
+			err := validateValue(value, name, fields)
+			if err != nil {
+				return err
+			}
+		}
+
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			err := validateValue(v.Index(i), fmt.Sprintf("%s[%d]", path, i), fields)
+			if err != nil {
+				return err
+			}
+		}
+
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			err := validateValue(iter.Value(), fmt.Sprintf("%s[%v]", path, iter.Key()), fields)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// fieldName returns the name of the field in errors: the name of the request parameter
+// or the name in JSON.
+func fieldName(field reflect.StructField) string {
+	for _, source := range paramSources {
+		if name, ok := field.Tag.Lookup(source); ok {
+			return name
+		}
+	}
+
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "" || name == "-" {
+		return field.Name
+	}
+	return name
+}
+
+// parseValidateTag splits a validate tag into its rules.
+func parseValidateTag(tag string) ([]validationRule, error) {
+	var rules []validationRule
+	for tag != "" {
+		var part string
+		if strings.HasPrefix(tag, "regex=") {
+			// the pattern can contain commas, so it takes the rest of the tag
+			part, tag = tag, ""
+		} else {
+			part, tag, _ = strings.Cut(tag, ",")
+		}
+
+		name, param, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch name {
+		case "required":
+		case "min", "max":
+			if _, err := strconv.ParseFloat(param, 64); err != nil {
+				return nil, fmt.Errorf("invalid %s rule %q", name, param)
+			}
+		case "enum":
+			if param == "" {
+				return nil, fmt.Errorf("enum rule without values")
+			}
+		case "regex":
+			if _, err := compileRegex(param); err != nil {
+				return nil, fmt.Errorf("invalid regex rule: %w", err)
+			}
+		case "":
+			continue
+		default:
+			return nil, fmt.Errorf("unknown validation rule %q", name)
+		}
+		rules = append(rules, validationRule{name: name, param: param})
+	}
+	return rules, nil
+}
+
+// compileRegex compiles the pattern, reusing earlier results.
+func compileRegex(pattern string) (*regexp.Regexp, error) {
+	if re, ok := regexCache.Load(pattern); ok {
+		return re.(*regexp.Regexp), nil
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	regexCache.Store(pattern, re)
+	return re, nil
+}
+
+// checkRules returns the message of the first rule the value violates, or "" if it is valid.
+func checkRules(v reflect.Value, rules []validationRule) (string, error) {
+	for _, rule := range rules {
+		if rule.name == "required" {
+			if v.IsZero() {
+				return "is required", nil
+			}
+			continue
+		}
+
+		// the other rules only apply to values that are set
+		value := v
+		for value.Kind() == reflect.Pointer || value.Kind() == reflect.Interface {
+			if value.IsNil() {
+				return "", nil
+			}
+			value = value.Elem()
+		}
+
+		message, err := checkRule(value, rule)
+		if err != nil || message != "" {
+			return message, err
+		}
+	}
+	return "", nil
+}
+
+// checkRule checks a single rule (except required) against a value that is not a pointer.
+func checkRule(v reflect.Value, rule validationRule) (string, error) {
+	switch rule.name {
+	case "min", "max":
+		limit, _ := strconv.ParseFloat(rule.param, 64)
+
+		var actual float64
+		var unit string
+		switch v.Kind() {
+		case reflect.String:
+			actual = float64(utf8.RuneCountInString(v.String()))
+			unit = " characters"
+		case reflect.Slice, reflect.Array, reflect.Map:
+			actual = float64(v.Len())
+			unit = " items"
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			actual = float64(v.Int())
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			actual = float64(v.Uint())
+		case reflect.Float32, reflect.Float64:
+			actual = v.Float()
+		default:
+			return "", fmt.Errorf("%s rule is not supported for %s", rule.name, v.Kind())
+		}
+
+		if rule.name == "min" && actual < limit {
+			if unit != "" {
+				return "must have at least " + rule.param + unit, nil
+			}
+			return "must be at least " + rule.param, nil
+		}
+		if rule.name == "max" && actual > limit {
+			if unit != "" {
+				return "must have at most " + rule.param + unit, nil
+			}
+			return "must be at most " + rule.param, nil
+		}
+
+	case "enum":
+		switch v.Kind() {
+		case reflect.Struct, reflect.Slice, reflect.Array, reflect.Map:
+			return "", fmt.Errorf("enum rule is not supported for %s", v.Kind())
+		}
+
+		allowed := strings.Split(rule.param, "|")
+		actual := fmt.Sprint(v.Interface())
+		for _, value := range allowed {
+			if actual == value {
+				return "", nil
+			}
+		}
+		return "must be one of " + strings.Join(allowed, ", "), nil
+
+	case "regex":
+		if v.Kind() != reflect.String {
+			return "", fmt.Errorf("regex rule is not supported for %s", v.Kind())
+		}
+
+		re, err := compileRegex(rule.param)
+		if err != nil {
+			return "", err
+		}
+		if !re.MatchString(v.String()) {
+			return "must match " + rule.param, nil
+		}
+	}
+
+	return "", nil
+}
@@ -1,6 +1,12 @@
 package karotteapi
 
-import "net/http"
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+)
 
 // Config contains all details to create a new api.
 type Config map[string]any
@@ -21,6 +27,10 @@ type Middleware struct {
 
 	// Handler is the http.Handler of the middleware.
 	Handler func(http.Handler) (handler http.Handler)
+
+	// OnConfigChange is a function that is run when the config of the middleware changed during a reload.
+	// It receives the old and the new config block of the middleware. It can be nil if not needed.
+	OnConfigChange func(oldConfig, newConfig Config) error
 }
 
 // Module is the struct the module needs to provide to the module registry to register itself.
@@ -43,9 +53,234 @@ type Module struct {
 	// Shutdown is a function that is run on shutdown.
 	// This can be used to cleanly disconnect from services connected during Startup().
 	Shutdown func() error
+
+	// OnConfigChange is a function that is run when the config of the module changed during a reload.
+	// It receives the old and the new config block of the module. It can be nil if not needed.
+	OnConfigChange func(oldConfig, newConfig Config) error
+
+	// Operations describes the routes of the module for the OpenAPI document. It can be nil if not needed.
+	Operations func() []Operation
+}
+
+// Operation describes a route of a module for the OpenAPI document.
+type Operation struct {
+	// Method is the HTTP method (e.g. "GET").
+	Method string
+
+	// Path is the full path of the route. Path parameters use the syntax of http.ServeMux (e.g. "/orders/{id}").
+	Path string
+
+	// OperationID is a unique id of the operation (e.g. "getOrder"). It is optional.
+	OperationID string
+
+	// Summary is a short description of the operation.
+	Summary string
+
+	// Description is a longer description of the operation. It can contain Markdown.
+	Description string
+
+	// Tags group the operations in the documentation. The default is the name of the module.
+	Tags []string
+
+	// Request is a value of the request type (e.g. CreateOrder{}). Fields with a path, query or header tag
+	// are documented as parameters, all other fields as JSON body (see core.Handle). It can be nil.
+	Request any
+
+	// Response is a value of the response type (e.g. Order{}). If it is nil, the operation responds with 204.
+	Response any
+
+	// ResponseType is the media type of the response. The default is "application/json".
+	ResponseType string
+
+	// Status is the status code of a successful response. The default is 200, or the result of
+	// a method StatusCode() int of the response.
+	Status int
+
+	// Permissions are the permissions required for the operation.
+	Permissions []string
+
+	// Deprecated marks the operation as deprecated.
+	Deprecated bool
+}
+
+// SecretProvider resolves secret references in config values.
+// A config value "secret://<name>/<reference>" is resolved by the provider registered under <name>.
+type SecretProvider interface {
+	// Resolve returns the secret for the reference.
+	Resolve(reference string) (string, error)
+}
+
+// Principal is the authenticated identity of a request.
+// It is stored in the request context by the auth middleware.
+type Principal struct {
+	// ID identifies the principal (e.g. the user name or the service name).
+	ID string
+
+	// Roles are the roles of the principal. They can be used for authorization.
+	Roles []string
+
+	// Attributes contains additional data provided by the authenticator (e.g. token claims).
+	Attributes map[string]any
+
+	// Method is the name of the authenticator that authenticated the request.
+	Method string
+}
+
+// Authenticator authenticates requests for the auth middleware.
+type Authenticator interface {
+	// Authenticate returns the principal of the request.
+	// It returns ErrNoCredentials if the request contains no credentials for this authenticator,
+	// so the next authenticator is tried. Any other error rejects the request.
+	Authenticate(r *http.Request) (*Principal, error)
+}
+
+// AuthenticatorFactory creates an Authenticator from its config block.
+type AuthenticatorFactory func(config Config) (Authenticator, error)
+
+// ErrNoCredentials is returned by an Authenticator if the request contains no credentials for it.
+var ErrNoCredentials = errors.New("no credentials")
+
+// PermissionProvider decides which permissions a principal has.
+type PermissionProvider interface {
+	// HasPermission reports whether the principal has the permission (e.g. "orders:write").
+	HasPermission(principal *Principal, permission string) (bool, error)
+}
+
+// RateLimit describes a rate limit.
+type RateLimit struct {
+	// Algorithm is "token_bucket" or "sliding_window".
+	Algorithm string
+
+	// Limit is the number of requests allowed per Window.
+	Limit int
+
+	// Window is the time window of the limit.
+	Window time.Duration
+
+	// Burst is the capacity of the token bucket. It is only used by "token_bucket".
+	Burst int
+}
+
+// RateLimitResult is the result of taking a request from a rate limit.
+type RateLimitResult struct {
+	// Allowed is true if the request is within the limit.
+	Allowed bool
+
+	// Remaining is the number of requests left.
+	Remaining int
+
+	// Reset is the time until the limit is fully available again.
+	Reset time.Duration
+
+	// RetryAfter is the time until the next request is allowed. It is only set if Allowed is false.
+	RetryAfter time.Duration
+}
+
+// RateLimitStore keeps the state of rate limits, for example in memory or in Redis.
+type RateLimitStore interface {
+	// Take takes one request for the key from the rate limit.
+	Take(ctx context.Context, key string, limit RateLimit) (RateLimitResult, error)
+}
+
+// ConfigReport describes the effective config and how it is used.
+type ConfigReport struct {
+	// Config is the effective config with all sensitive values redacted.
+	Config Config `json:"config"`
+
+	// Blocks are the server, module and middleware config blocks.
+	Blocks []ConfigBlock `json:"blocks"`
+
+	// UnreadKeys are the paths of all config values that were never read.
+	UnreadKeys []string `json:"unreadKeys"`
+}
+
+// ConfigBlock describes the usage of a config block.
+type ConfigBlock struct {
+	// Path is the path of the block (e.g. "modules.health").
+	Path string `json:"path"`
+
+	// Kind is "server", "module" or "middleware".
+	Kind string `json:"kind"`
+
+	// Registered is true if a module or middleware with the name of the block is registered.
+	Registered bool `json:"registered"`
+
+	// Consumed is true if the block was requested through the config functions.
+	Consumed bool `json:"consumed"`
+
+	// ReadKeys are the paths of all values inside the block that were read.
+	ReadKeys []string `json:"readKeys"`
+}
+
+// HTTPError is an error with an HTTP status code.
+// It is written as RFC 9457 problem details ("application/problem+json") by core.WriteError.
+type HTTPError struct {
+	// Status is the HTTP status code of the response.
+	Status int
+
+	// Code is a machine-readable error code (e.g. "rate_limited"). It is optional.
+	Code string
+
+	// Detail is a human-readable explanation of this occurrence of the error.
+	Detail string
+
+	// Type is a URI identifying the problem type. It defaults to "about:blank".
+	Type string
+
+	// Fields are the errors of single request fields, e.g. failed validations.
+	Fields []FieldError
+
+	// Extensions are additional members of the problem details object (e.g. "error_id").
+	Extensions map[string]any
+
+	// Err is the cause of the error. It is logged (as error for 5xx, at debug level for 4xx), but never sent to the client.
+	Err error
+}
+
+func (e *HTTPError) Error() string {
+	message := http.StatusText(e.Status)
+	if e.Detail != "" {
+		message += ": " + e.Detail
+	}
+	if e.Err != nil {
+		message += ": " + e.Err.Error()
+	}
+	return message
+}
+
+func (e *HTTPError) Unwrap() error {
+	return e.Err
+}
+
+// FieldError describes why a single request field is invalid.
+type FieldError struct {
+	// Field is the name of the field (e.g. "email" or "items[0].count").
+	Field string `json:"field"`
+
+	// Message describes the error.
+	Message string `json:"message"`
+}
+
+// Encoder writes a response value in a media type (e.g. XML) for core.WriteResponse.
+type Encoder func(w io.Writer, v any) error
+
+// PanicInfo describes a panic caught by the recovery middleware.
+type PanicInfo struct {
+	// Value is the value passed to panic().
+	Value any
+
+	// Stack is the stack trace of the panicking goroutine.
+	Stack []byte
+
+	// ErrorID identifies the panic. It is returned to the client, so reports can be matched with the logs.
+	ErrorID string
+
+	// Request is the request that caused the panic.
+	Request *http.Request
 }
 
 // RequestContext can be used to pass additional information between Middleware and Module.
+// New code should prefer the type-safe core.ContextKey, RequestContext is kept for compatibility.
 type RequestContext struct {
 	// Info is any data that needs to be passed with the request.
 	Info any
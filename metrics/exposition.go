@@ -0,0 +1,133 @@
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	// ContentTypeText is the content type of the Prometheus text format.
+	ContentTypeText = "text/plain; version=0.0.4; charset=utf-8"
+
+	// ContentTypeOpenMetrics is the content type of the OpenMetrics text format.
+	ContentTypeOpenMetrics = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+)
+
+// helpEscaper escapes HELP texts.
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+// labelEscaper escapes label values.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+// formatFloat formats a sample value.
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// writeSample writes one sample line. extraName and extraValue add a label (e.g. "le").
+func writeSample(w *bufio.Writer, name string, labels []string, values []string, extraName string, extraValue string, value string) {
+	w.WriteString(name)
+
+	if len(labels) > 0 || extraName != "" {
+		w.WriteByte('{')
+		for i, label := range labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			w.WriteString(label)
+			w.WriteString(`="`)
+			w.WriteString(labelEscaper.Replace(values[i]))
+			w.WriteByte('"')
+		}
+		if extraName != "" {
+			if len(labels) > 0 {
+				w.WriteByte(',')
+			}
+			w.WriteString(extraName)
+			w.WriteString(`="`)
+			w.WriteString(extraValue)
+			w.WriteByte('"')
+		}
+		w.WriteByte('}')
+	}
+
+	w.WriteByte(' ')
+	w.WriteString(value)
+	w.WriteByte('\n')
+}
+
+// Write writes all metrics in the Prometheus text format,
+// or in the OpenMetrics text format if openMetrics is true.
+func (r *Registry) Write(out io.Writer, openMetrics bool) error {
+	w := bufio.NewWriter(out)
+
+	for _, f := range r.sortedFamilies() {
+		name := f.name
+		sampleName := f.name
+		if openMetrics && f.kind == kindCounter {
+			// OpenMetrics names the counter family without the _total suffix
+			name = strings.TrimSuffix(f.name, "_total")
+			sampleName = name + "_total"
+		}
+
+		if f.help != "" {
+			w.WriteString("# HELP " + name + " " + helpEscaper.Replace(f.help) + "\n")
+		}
+		w.WriteString("# TYPE " + name + " " + string(f.kind) + "\n")
+
+		if f.fn != nil {
+			writeSample(w, sampleName, nil, nil, "", "", formatFloat(f.fn()))
+			continue
+		}
+
+		for _, s := range f.sortedSeries() {
+			if f.kind != kindHistogram {
+				writeSample(w, sampleName, f.labels, s.labelValues, "", "", formatFloat(math.Float64frombits(s.value.Load())))
+				continue
+			}
+
+			s.lock.Lock()
+			counts := append([]uint64(nil), s.counts...)
+			sum, count := s.sum, s.count
+			s.lock.Unlock()
+
+			for i, bound := range f.buckets {
+				writeSample(w, name+"_bucket", f.labels, s.labelValues, "le", formatFloat(bound), strconv.FormatUint(counts[i], 10))
+			}
+			writeSample(w, name+"_bucket", f.labels, s.labelValues, "le", "+Inf", strconv.FormatUint(count, 10))
+			writeSample(w, name+"_sum", f.labels, s.labelValues, "", "", formatFloat(sum))
+			writeSample(w, name+"_count", f.labels, s.labelValues, "", "", strconv.FormatUint(count, 10))
+		}
+	}
+
+	if openMetrics {
+		w.WriteString("# EOF\n")
+	}
+
+	return w.Flush()
+}
+
+// ServeHTTP writes all metrics. The OpenMetrics format is used if the client accepts it.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	openMetrics := strings.Contains(req.Header.Get("Accept"), "application/openmetrics-text")
+
+	if openMetrics {
+		w.Header().Set("Content-Type", ContentTypeOpenMetrics)
+	} else {
+		w.Header().Set("Content-Type", ContentTypeText)
+	}
+
+	r.Write(w, openMetrics)
+}
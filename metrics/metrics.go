@@ -0,0 +1,183 @@
+package metrics
+
+// This package contains a small metrics registry with counters, gauges and histograms.
+// The registry is written in the Prometheus text format or in the OpenMetrics format.
+//
+// Label values are passed to every call in the order of the label names:
+//
+//   requests := registry.Counter("app_requests_total", "Handled requests.", "method")
+//   requests.Inc("GET")
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultBuckets are the default histogram buckets for durations in seconds.
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// kind is the metric type.
+type kind string
+
+const (
+	kindCounter   kind = "counter"
+	kindGauge     kind = "gauge"
+	kindHistogram kind = "histogram"
+)
+
+// labelSeparator separates label values in series keys. It can not appear in valid UTF-8.
+const labelSeparator = "\xff"
+
+// family is a metric with all its series.
+type family struct {
+	name   string
+	help   string
+	kind   kind
+	labels []string
+
+	// buckets are the upper bounds of histogram buckets, without +Inf.
+	buckets []float64
+
+	// fn returns the value of metrics without labels that are computed on collect.
+	fn func() float64
+
+	lock   sync.RWMutex
+	series map[string]*series
+}
+
+// series is a metric with one set of label values.
+type series struct {
+	labelValues []string
+
+	// value is the float64 bits of counters and gauges.
+	value atomic.Uint64
+
+	// histogram values, guarded by lock
+	lock   sync.Mutex
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// getSeries returns the series of the label values and creates it if needed.
+// It panics if the number of label values does not match the labels.
+func (f *family) getSeries(labelValues []string) *series {
+	if len(labelValues) != len(f.labels) {
+		panic("metrics: " + f.name + " expects " + strings.Join(f.labels, ", ") + " label values")
+	}
+
+	key := strings.Join(labelValues, labelSeparator)
+
+	f.lock.RLock()
+	s, ok := f.series[key]
+	f.lock.RUnlock()
+	if ok {
+		return s
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	s, ok = f.series[key]
+	if !ok {
+		s = &series{labelValues: append([]string(nil), labelValues...)}
+		if f.kind == kindHistogram {
+			s.counts = make([]uint64, len(f.buckets))
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+// sortedSeries returns all series sorted by their label values.
+func (f *family) sortedSeries() []*series {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+
+	list := make([]*series, 0, len(f.series))
+	for _, s := range f.series {
+		list = append(list, s)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return strings.Join(list[i].labelValues, labelSeparator) < strings.Join(list[j].labelValues, labelSeparator)
+	})
+	return list
+}
+
+// add atomically adds v to the value of the series.
+func (s *series) add(v float64) {
+	for {
+		old := s.value.Load()
+		if s.value.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+v)) {
+			return
+		}
+	}
+}
+
+// Counter is a value that only increases, e.g. the number of handled requests.
+type Counter struct {
+	family *family
+}
+
+// Inc increases the counter by 1.
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add increases the counter by v. Negative values are ignored.
+func (c *Counter) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		return
+	}
+	c.family.getSeries(labelValues).add(v)
+}
+
+// Gauge is a value that can go up and down, e.g. the number of running requests.
+type Gauge struct {
+	family *family
+}
+
+// Set sets the gauge to v.
+func (g *Gauge) Set(v float64, labelValues ...string) {
+	g.family.getSeries(labelValues).value.Store(math.Float64bits(v))
+}
+
+// Inc increases the gauge by 1.
+func (g *Gauge) Inc(labelValues ...string) {
+	g.Add(1, labelValues...)
+}
+
+// Dec decreases the gauge by 1.
+func (g *Gauge) Dec(labelValues ...string) {
+	g.Add(-1, labelValues...)
+}
+
+// Add adds v to the gauge.
+func (g *Gauge) Add(v float64, labelValues ...string) {
+	g.family.getSeries(labelValues).add(v)
+}
+
+// Histogram counts observations in buckets, e.g. request durations.
+type Histogram struct {
+	family *family
+}
+
+// Observe adds an observation to the histogram.
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	s := h.family.getSeries(labelValues)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for i, bound := range h.family.buckets {
+		if v <= bound {
+			s.counts[i]++
+		}
+	}
+	s.sum += v
+	s.count++
+}
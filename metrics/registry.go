@@ -0,0 +1,119 @@
+package metrics
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"sync"
+)
+
+// namePattern matches valid metric and label names.
+var namePattern = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+
+// Registry holds metrics and writes them in the Prometheus or OpenMetrics text format.
+type Registry struct {
+	lock     sync.RWMutex
+	families map[string]*family
+
+	// hooks are called before the metrics are written, e.g. to update gauges.
+	hooks []func()
+}
+
+// NewRegistry creates an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{families: map[string]*family{}}
+}
+
+// register adds a metric family to the registry.
+// If a metric with the name exists, it is returned if it has the same type and labels.
+// It panics on invalid names and conflicting registrations.
+func (r *Registry) register(f *family) *family {
+	if !namePattern.MatchString(f.name) {
+		panic(fmt.Sprintf("metrics: invalid metric name %q", f.name))
+	}
+	for _, label := range f.labels {
+		if !namePattern.MatchString(label) || label == "le" {
+			panic(fmt.Sprintf("metrics: invalid label name %q of %s", label, f.name))
+		}
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	existing, ok := r.families[f.name]
+	if ok {
+		if existing.kind != f.kind || !slices.Equal(existing.labels, f.labels) || existing.fn != nil || f.fn != nil {
+			panic(fmt.Sprintf("metrics: %s is already registered with a different type or labels", f.name))
+		}
+		return existing
+	}
+
+	f.series = map[string]*series{}
+	r.families[f.name] = f
+	return f
+}
+
+// Counter registers a counter. Counter names should end in "_total".
+func (r *Registry) Counter(name string, help string, labels ...string) *Counter {
+	return &Counter{r.register(&family{name: name, help: help, kind: kindCounter, labels: labels})}
+}
+
+// Gauge registers a gauge.
+func (r *Registry) Gauge(name string, help string, labels ...string) *Gauge {
+	return &Gauge{r.register(&family{name: name, help: help, kind: kindGauge, labels: labels})}
+}
+
+// Histogram registers a histogram. If buckets is nil, DefaultBuckets are used.
+func (r *Registry) Histogram(name string, help string, buckets []float64, labels ...string) *Histogram {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	buckets = slices.Clone(buckets)
+	sort.Float64s(buckets)
+
+	return &Histogram{r.register(&family{name: name, help: help, kind: kindHistogram, labels: labels, buckets: buckets})}
+}
+
+// GaugeFunc registers a gauge without labels whose value is computed when the metrics are written.
+func (r *Registry) GaugeFunc(name string, help string, fn func() float64) {
+	r.register(&family{name: name, help: help, kind: kindGauge, fn: fn})
+}
+
+// CounterFunc registers a counter without labels whose value is computed when the metrics are written.
+func (r *Registry) CounterFunc(name string, help string, fn func() float64) {
+	r.register(&family{name: name, help: help, kind: kindCounter, fn: fn})
+}
+
+// OnCollect registers a function that is called before the metrics are written,
+// e.g. to update gauges from another source.
+func (r *Registry) OnCollect(hook func()) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.hooks = append(r.hooks, hook)
+}
+
+// sortedFamilies runs the collect hooks and returns all families sorted by name.
+func (r *Registry) sortedFamilies() []*family {
+	r.lock.RLock()
+	hooks := slices.Clone(r.hooks)
+	r.lock.RUnlock()
+
+	for _, hook := range hooks {
+		hook()
+	}
+
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	list := make([]*family, 0, len(r.families))
+	for _, f := range r.families {
+		list = append(list, f)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].name < list[j].name
+	})
+	return list
+}